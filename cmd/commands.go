@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/httpcache"
+)
+
+// exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+const (
+	// defaultCacheMaxSize is the default size limit of the Github API
+	// responses cache, in MB
+	defaultCacheMaxSize = 500
+
+	defaultSnapshot = "snapshot.json"
+	defaultAddr     = ":8080"
+)
+
+// errUsage is returned by commands invoked with the wrong arguments, once the
+// problem and the command usage have been printed
+var errUsage = errors.New("invalid usage")
+
+// command is a subcommand of the CLI. run receives the arguments following
+// the command name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"fetch", "fetch the CI data from Github into a snapshot file", runFetch},
+	{"report", "render the html report or the metrics from a snapshot file, without accessing the network", runReport},
+	{"serve", "serve the html report and the metrics over HTTP, refreshing the data in the background", runServe},
+	{"diff", "compare the success rates and error messages of two snapshot files", runDiff},
+}
+
+// run executes the command named by the first argument and returns the
+// process exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		err := cmd.run(args[1:])
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		default:
+			log.Printf("%s: %s", cmd.name, err)
+			return exitFailure
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+// usage prints the list of commands to w
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [args]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of each command.\n", filepath.Base(os.Args[0]))
+}
+
+// newFlagSet returns the flag set for the named command, whose usage message
+// shows the command's positional arguments and description
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		synopsis := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", filepath.Base(os.Args[0]), name, args))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\nFlags:\n", synopsis, description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs, checking that they're followed by nArgs
+// positional arguments
+func parseFlags(fs *flag.FlagSet, args []string, nArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() != nArgs {
+		fmt.Fprintf(fs.Output(), "expected %d arguments, got %d\n\n", nArgs, fs.NArg())
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// fetchFlags holds the flags that drive fetching data from Github
+type fetchFlags struct {
+	config          string
+	apiURL          string
+	since           string
+	until           string
+	concurrency     int
+	requestsPerHour int
+	maxAttempts     int
+	store           string
+	cacheDir        string
+	cacheMaxSize    int64
+	noCache         bool
+
+	// opened is the store under the store directory, once opened
+	opened *store
+}
+
+// addFetchFlags registers in fs the flags that drive fetching data from Github
+func addFetchFlags(fs *flag.FlagSet) *fetchFlags {
+	ff := &fetchFlags{}
+	fs.StringVar(&ff.config, "config", "", "path to a JSON file declaring the repositories and workflows to report on (defaults to the linkerd2 workflows)")
+	fs.StringVar(&ff.apiURL, "github-api-url", defaultAPIURL, "base URL of the Github API")
+	fs.StringVar(&ff.since, "since", "", "start of the reporting window, as a date (2006-01-02 or RFC3339) or a duration going back from now (7d, 2w, 36h); defaults to a month before -until")
+	fs.StringVar(&ff.until, "until", "", "end of the reporting window, in the same formats as -since; defaults to now")
+	fs.IntVar(&ff.concurrency, "concurrency", defaultConcurrency, "maximum number of concurrent requests to the Github API")
+	fs.IntVar(&ff.requestsPerHour, "rate-limit", defaultRequestsPerHour, "maximum number of requests per hour to the Github API")
+	fs.IntVar(&ff.maxAttempts, "max-attempts", defaultMaxAttempts, "maximum number of attempts for each request to the Github API, when failing with a transient error")
+	fs.StringVar(&ff.store, "store", "", "directory where the fetched data is persisted, so that only new data is fetched on subsequent runs")
+	fs.StringVar(&ff.cacheDir, "cache-dir", defaultCacheDir(), "directory where the Github API responses are cached")
+	fs.Int64Var(&ff.cacheMaxSize, "cache-max-size", defaultCacheMaxSize, "maximum size of the Github API responses cache, in MB")
+	fs.BoolVar(&ff.noCache, "no-cache", false, "bypass the Github API responses cache")
+	return ff
+}
+
+// defaultCacheDir returns the directory for the Github API responses cache
+// under the user's cache directory, or an empty string (disabling the cache)
+// if there's none
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "linkerd2-ci-metrics")
+}
+
+// fetch retrieves from Github the data for the repos, workflows and window
+// given by the flags. The token is read from the GITHUB_TOKEN env var.
+func (ff *fetchFlags) fetch(ctx context.Context) (*snapshot, error) {
+	f, cache, err := ff.newFetcher()
+	if err != nil {
+		return nil, err
+	}
+	return ff.fetchWith(ctx, f, cache)
+}
+
+// fetchWith retrieves from Github the data for the repos, workflows and
+// window given by the flags with the fetcher f, whose window is set to the
+// one given by the flags as of now, logging the stats of the cache if not
+// nil. Reusing f keeps the pace learned by its rate limiter across fetches.
+func (ff *fetchFlags) fetchWith(ctx context.Context, f *fetcher, cache *httpcache.Transport) (*snapshot, error) {
+	cfg, err := loadConfig(ff.config)
+	if err != nil {
+		return nil, err
+	}
+	fetchedAt := time.Now()
+	w, err := parseWindow(ff.since, ff.until, fetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	f.window = w
+
+	jobs, annotations, err := f.getData(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		stats := cache.Stats()
+		log.Printf("cache: %d hits, %d misses (%.0f%% hit ratio)", stats.Hits, stats.Misses, 100*stats.HitRatio())
+	}
+	repos := make([]string, len(cfg.Repos))
+	for i, repo := range cfg.Repos {
+		repos[i] = repo.fullName()
+	}
+	return &snapshot{
+		FetchedAt:   fetchedAt,
+		APIURL:      ff.apiURL,
+		Repos:       repos,
+		Window:      w,
+		Jobs:        jobs,
+		Annotations: annotations,
+	}, nil
+}
+
+// newFetcher returns a fetcher for the Github API given by the flags, using
+// the store if one was given, along with the cache of the API responses if
+// it's enabled. The token is read from the GITHUB_TOKEN env var.
+func (ff *fetchFlags) newFetcher() (*fetcher, *httpcache.Transport, error) {
+	token, ok := os.LookupEnv(tokenLabel)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s env var required", tokenLabel)
+	}
+
+	var cache *httpcache.Transport
+	var err error
+	if !ff.noCache && ff.cacheDir != "" {
+		if cache, err = httpcache.New(ff.cacheDir, ff.cacheMaxSize<<20, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	var transport http.RoundTripper
+	if cache != nil {
+		transport = cache
+	}
+	client, err := newClient(token, ff.apiURL, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	f := newFetcher(client, ff.concurrency, ff.requestsPerHour, ff.maxAttempts)
+	if f.store, err = ff.openStore(); err != nil {
+		return nil, nil, err
+	}
+	return f, cache, nil
+}
+
+// openStore returns the store under the store directory, nil if none was
+// given. It's opened once, and then shared by all the fetchers.
+func (ff *fetchFlags) openStore() (*store, error) {
+	if ff.store == "" || ff.opened != nil {
+		return ff.opened, nil
+	}
+	s, err := openStore(ff.store)
+	if err != nil {
+		return nil, err
+	}
+	ff.opened = s
+	return s, nil
+}
+
+// reportFlags holds the flags that tweak how reports are built
+type reportFlags struct {
+	config            *string
+	previous          string
+	slowdownThreshold int
+	bucket            string
+	top               int
+}
+
+// addReportFlags registers in fs the flags that tweak how reports are built.
+// If the fetch flags ff were registered in fs too, their config file is used.
+func addReportFlags(fs *flag.FlagSet, ff *fetchFlags) *reportFlags {
+	rf := &reportFlags{}
+	if ff != nil {
+		rf.config = &ff.config
+	} else {
+		rf.config = fs.String("config", "", "path to a JSON config file, whose message rules and cluster threshold tell how the error messages are grouped")
+	}
+	fs.StringVar(&rf.previous, "previous", "", "snapshot file of the previous window, to compare the job durations and, in the markdown summary, the success rates against")
+	fs.IntVar(&rf.slowdownThreshold, "slowdown-threshold", defaultSlowdownThreshold, "growth of a job's median duration versus the previous window, in percent, above which it's reported as slower")
+	fs.IntVar(&rf.top, "top", defaultTop, "number of jobs and error messages ranked in the markdown summary")
+	fs.StringVar(&rf.bucket, "bucket", "", "period over which the success rates are aggregated in the trend charts, day or week (defaults to day for windows up to a month, and week beyond)")
+	return rf
+}
+
+// options returns the report options given by the flags, reading the config
+// file and the previous snapshot if they were given
+func (rf *reportFlags) options() (reportOptions, error) {
+	if err := validBucket(rf.bucket); err != nil {
+		return reportOptions{}, err
+	}
+	if rf.top < 1 {
+		return reportOptions{}, fmt.Errorf("invalid -top %d, expected at least 1", rf.top)
+	}
+	cfg, err := loadConfig(*rf.config)
+	if err != nil {
+		return reportOptions{}, err
+	}
+	opts := reportOptions{
+		slowdownThreshold: rf.slowdownThreshold,
+		bucket:            rf.bucket,
+		clusterer:         cfg.clusterer(),
+		top:               rf.top,
+	}
+	if rf.previous != "" {
+		if opts.previous, err = readSnapshot(rf.previous); err != nil {
+			return reportOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+func runFetch(args []string) error {
+	fs := newFlagSet("fetch", "", "Fetches the CI jobs and the error messages of the failed ones from Github,\n"+
+		"and saves them into a snapshot file. Requires the GITHUB_TOKEN env var.")
+	output := fs.String("o", defaultSnapshot, "path of the snapshot file to write")
+	ff := addFetchFlags(fs)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	s, err := ff.fetch(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshot(*output, s); err != nil {
+		return err
+	}
+	log.Printf("saved %d jobs and %d annotations to %s", len(s.Jobs), len(s.Annotations), *output)
+	return nil
+}
+
+func runReport(args []string) error {
+	fs := newFlagSet("report", "SNAPSHOT", "Renders the report from a snapshot file written by the fetch command. SNAPSHOT\n"+
+		"can also be a directory holding the jobs.json and annotations.json files of the\n"+
+		"format that predates snapshots.")
+	output := fs.String("o", "", "path of the file to write, replaced at once once complete (defaults to stdout)")
+	format := fs.String("format", "html", "output format: html; markdown for a summary to post in an issue or a job summary; json for the computed metrics; prometheus for the text exposition format read by the node exporter's textfile collector; "+
+		"csv-jobs, csv-annotations, csv-job-totals or csv-workflow-totals for the job runs, their annotations or the totals per job or workflow as CSV")
+	rf := addReportFlags(fs, nil)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return err
+	}
+
+	renderer, ok := renderers[*format]
+	if !ok {
+		return fmt.Errorf("unknown format %q, expected one of %s", *format, strings.Join(formatNames(), ", "))
+	}
+	s, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	return renderFile(renderer, newReport(s, opts), *output)
+}
+
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "", "Serves the html report at /, the Prometheus metrics at /metrics, the data as JSON\n"+
+		"under /api/ and the health status at /healthz. The data is fetched from Github\n"+
+		"every -refresh interval in the background, which requires the GITHUB_TOKEN env var.\n"+
+		"With -refresh 0 the data is read from the -snapshot file instead, and reloaded\n"+
+		"whenever the file changes, so that it can be updated by running the fetch command\n"+
+		"periodically. When the GITHUB_WEBHOOK_SECRET env var is set, the workflow_run,\n"+
+		"workflow_job and check_run deliveries of a Github webhook signed with that secret\n"+
+		"are received at /webhook, adding the jobs to the data as soon as they complete.")
+	addr := fs.String("addr", defaultAddr, "address to listen on")
+	path := fs.String("snapshot", "", "snapshot file the data is served from until the first refresh, and saved to after each refresh")
+	refresh := fs.Duration("refresh", defaultRefreshInterval, "interval between two fetches of the data from Github, 0 to serve the -snapshot file instead")
+	ff := addFetchFlags(fs)
+	rf := addReportFlags(fs, ff)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	opts, err := rf.options()
+	if err != nil {
+		return err
+	}
+	srv := newServer(opts)
+	var hook *webhook
+	var src source
+	var delay, interval time.Duration
+	if *refresh > 0 {
+		// the fetcher is shared by all the refreshes and the webhook, so that
+		// they stay within the same rate limit and its rate limiter keeps
+		// the pace it learned
+		f, cache, err := ff.newFetcher()
+		if err != nil {
+			return err
+		}
+		src, interval = fetchSource(ff, f, cache, *path), *refresh
+		if secret := os.Getenv(webhookSecretLabel); secret != "" {
+			cfg, err := loadConfig(ff.config)
+			if err != nil {
+				return err
+			}
+			hook = newWebhook([]byte(secret), cfg, f, srv)
+			srv.webhook = hook
+		}
+		// a recent enough snapshot is served until the next refresh is due
+		if *path != "" {
+			if s, err := readSnapshot(*path); err == nil {
+				srv.update(s)
+				delay = time.Until(s.FetchedAt.Add(*refresh))
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		if *path == "" {
+			return errors.New("-snapshot is required when -refresh is 0")
+		}
+		if os.Getenv(webhookSecretLabel) != "" {
+			return fmt.Errorf("the webhook deliveries can't be received when -refresh is 0, as the data is read from the -snapshot file; unset %s", webhookSecretLabel)
+		}
+		src, delay, interval = fileSource(*path), reloadInterval, reloadInterval
+		s, err := src(context.Background())
+		if err != nil {
+			return err
+		}
+		srv.update(s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	go srv.refreshLoop(ctx, src, delay, interval)
+	if hook != nil {
+		go hook.run(ctx)
+	}
+	log.Printf("serving on %s", l.Addr())
+	return srv.serve(ctx, l)
+}
+
+func runDiff(args []string) error {
+	fs := newFlagSet("diff", "OLD NEW", "Compares two snapshot files, showing how the success rates of the workflows and\n"+
+		"jobs changed, and which error messages appeared or went away.")
+	threshold := fs.Int("threshold", 0, "only show the workflows and jobs whose success rate changed by more than this many points")
+	configPath := fs.String("config", "", "path to a JSON config file, whose message rules tell how the error messages are normalized")
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	before, err := readSnapshot(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := readSnapshot(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	return diffSnapshots(before, after, cfg.clusterer().Normalizer).print(os.Stdout, *threshold)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/fakegithub"
+)
+
+func TestRunUsage(t *testing.T) {
+	testCases := []struct {
+		args []string
+		code int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"bogus"}, exitUsage},
+		{[]string{"help"}, exitOK},
+		{[]string{"report", "-h"}, exitOK},
+		{[]string{"report"}, exitUsage},
+		{[]string{"report", "-bogus", "snapshot.json"}, exitUsage},
+		{[]string{"diff", "old.json"}, exitUsage},
+		{[]string{"report", "testdata/missing.json"}, exitFailure},
+		{[]string{"report", "-format", "bogus", "testdata"}, exitFailure},
+		{[]string{"serve", "snapshot.json"}, exitUsage},
+		{[]string{"serve", "-refresh", "0"}, exitFailure},
+	}
+	for _, tc := range testCases {
+		if code := run(tc.args); code != tc.code {
+			t.Errorf("%v: expected exit code %d, got %d", tc.args, tc.code, code)
+		}
+	}
+}
+
+func TestFetchAndReport(t *testing.T) {
+	server := fakegithub.New()
+	defer server.Close()
+	server.SetRateLimit(1000000, 1000000, time.Now().Add(time.Minute))
+	addWorkflowRuns(server, 3)
+
+	token, hadToken := os.LookupEnv(tokenLabel)
+	os.Setenv(tokenLabel, "token")
+	defer func() {
+		if hadToken {
+			os.Setenv(tokenLabel, token)
+		} else {
+			os.Unsetenv(tokenLabel)
+		}
+	}()
+
+	dir := t.TempDir()
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+	reportPath := filepath.Join(dir, "report.html")
+	config := filepath.Join(dir, "config.json")
+	configJSON := `{"repos": [{"owner": "linkerd", "repo": "linkerd2", "workflows": [{"file": "ci.yml", "name": "CI", "fetchAnnotations": true}]}]}`
+	if err := ioutil.WriteFile(config, []byte(configJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	code := run([]string{"fetch", "-config", config, "-github-api-url", server.URL, "-no-cache", "-since", "7d", "-o", snapshotPath})
+	if code != exitOK {
+		t.Fatalf("fetch failed with exit code %d", code)
+	}
+	s, err := readSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Jobs) != 6 || len(s.Annotations) != 3 {
+		t.Errorf("expected 6 jobs and 3 annotations, got %d and %d", len(s.Jobs), len(s.Annotations))
+	}
+	if d := s.Window.Until.Sub(s.Window.Since); d != 7*24*time.Hour {
+		t.Errorf("expected a 7 days window, got %s", d)
+	}
+
+	// rendering the report doesn't need the server
+	server.Close()
+	if code := run([]string{"report", "-o", reportPath, snapshotPath}); code != exitOK {
+		t.Fatalf("report failed with exit code %d", code)
+	}
+	if info, err := os.Stat(reportPath); err != nil || info.Size() == 0 {
+		t.Errorf("expected a non-empty report, got %v (%v)", info, err)
+	}
+
+	if code := run([]string{"report", "-format", "prometheus", "-o", reportPath, snapshotPath}); code != exitOK {
+		t.Fatalf("report failed with exit code %d", code)
+	}
+	metrics, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(metrics), `ci_job_runs{repo="linkerd/linkerd2",workflow="CI",job="unit tests"} 3`) {
+		t.Errorf("expected the metrics to hold the runs of the unit tests, got:\n%s", metrics)
+	}
+
+	// the message rules of the config group the messages of all the runs
+	if code := run([]string{"report", "-config", "testdata/config.json", "-o", reportPath, snapshotPath}); code != exitOK {
+		t.Fatalf("report failed with exit code %d", code)
+	}
+	report, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster := `"Key":"TestInstall - run \u003cn\u003e timed-out","Count":3`; !strings.Contains(string(report), cluster) {
+		t.Errorf("expected the report to hold the cluster %s", cluster)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+)
+
+// config declares the set of repositories and workflows the report is built
+// from, and how the error messages of their failed jobs are grouped
+type config struct {
+	Repos []repoConfig `json:"repos"`
+	// MessageRules are applied to the error messages after the builtin
+	// normalization rules
+	MessageRules []messageRule `json:"messageRules,omitempty"`
+	// ClusterThreshold is the minimal similarity of two normalized error
+	// messages for them to be grouped together, between 0 and 1
+	ClusterThreshold float64 `json:"clusterThreshold,omitempty"`
+}
+
+// messageRule replaces the matches of the Pattern regex in the error messages
+// with Replacement, which can refer to submatches as $1 or ${name}
+type messageRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// repoConfig identifies a Github repository and the workflows whose runs are
+// to be collected from it
+type repoConfig struct {
+	Owner     string           `json:"owner"`
+	Repo      string           `json:"repo"`
+	Workflows []workflowConfig `json:"workflows"`
+}
+
+// workflowConfig holds the workflow file name as found under
+// .github/workflows, the name under which it's displayed in the report and
+// whether the annotations of its failed jobs should be retrieved
+type workflowConfig struct {
+	File             string `json:"file"`
+	Name             string `json:"name"`
+	FetchAnnotations bool   `json:"fetchAnnotations"`
+}
+
+// defaultConfig returns the configuration used when no config file is
+// provided, which targets the linkerd2 repo
+func defaultConfig() *config {
+	return &config{
+		Repos: []repoConfig{
+			{
+				Owner: "linkerd",
+				Repo:  "linkerd2",
+				Workflows: []workflowConfig{
+					{File: "kind_integration.yml", Name: "KinD integration", FetchAnnotations: true},
+					{File: "cloud_integration.yml", Name: "Cloud integration", FetchAnnotations: true},
+					{File: "release.yml", Name: "Release", FetchAnnotations: true},
+					{File: "static_checks.yml", Name: "Static checks", FetchAnnotations: false},
+					{File: "unit_tests.yml", Name: "Unit tests", FetchAnnotations: false},
+				},
+			},
+		},
+	}
+}
+
+// loadConfig reads and validates the JSON config file at path. If path is
+// empty the default config is returned.
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		return defaultConfig(), nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: invalid config: %s", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &cfg, nil
+}
+
+// validate checks that all the required fields are set and that no repo or
+// workflow is declared more than once
+func (c *config) validate() error {
+	if len(c.Repos) == 0 {
+		return fmt.Errorf("at least one entry under \"repos\" is required")
+	}
+
+	repos := make(map[string]struct{})
+	for i, r := range c.Repos {
+		if r.Owner == "" {
+			return fmt.Errorf("repos[%d]: \"owner\" is required", i)
+		}
+		if r.Repo == "" {
+			return fmt.Errorf("repos[%d]: \"repo\" is required", i)
+		}
+		if _, ok := repos[r.fullName()]; ok {
+			return fmt.Errorf("repos[%d]: %s is declared more than once", i, r.fullName())
+		}
+		repos[r.fullName()] = struct{}{}
+
+		if len(r.Workflows) == 0 {
+			return fmt.Errorf("repos[%d] (%s): at least one entry under \"workflows\" is required", i, r.fullName())
+		}
+		files := make(map[string]struct{})
+		names := make(map[string]struct{})
+		for j, w := range r.Workflows {
+			prefix := fmt.Sprintf("repos[%d].workflows[%d] (%s)", i, j, r.fullName())
+			if w.File == "" {
+				return fmt.Errorf("%s: \"file\" is required", prefix)
+			}
+			if !strings.HasSuffix(w.File, ".yml") && !strings.HasSuffix(w.File, ".yaml") {
+				return fmt.Errorf("%s: \"file\" must be a workflow file name ending in .yml or .yaml, got %q", prefix, w.File)
+			}
+			if w.Name == "" {
+				return fmt.Errorf("%s: \"name\" is required", prefix)
+			}
+			if _, ok := files[w.File]; ok {
+				return fmt.Errorf("%s: workflow file %q is declared more than once", prefix, w.File)
+			}
+			files[w.File] = struct{}{}
+			if _, ok := names[w.Name]; ok {
+				return fmt.Errorf("%s: workflow name %q is declared more than once", prefix, w.Name)
+			}
+			names[w.Name] = struct{}{}
+		}
+	}
+
+	for i, r := range c.MessageRules {
+		if r.Pattern == "" {
+			return fmt.Errorf("messageRules[%d]: \"pattern\" is required", i)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("messageRules[%d]: invalid pattern: %s", i, err)
+		}
+	}
+	if c.ClusterThreshold < 0 || c.ClusterThreshold > 1 {
+		return fmt.Errorf("\"clusterThreshold\" must be between 0 and 1, got %v", c.ClusterThreshold)
+	}
+	return nil
+}
+
+// clusterer returns the clusterer grouping the error messages according to
+// the config
+func (c *config) clusterer() normalize.Clusterer {
+	rules := make([]normalize.Rule, len(c.MessageRules))
+	for i, r := range c.MessageRules {
+		// patterns are checked by validate
+		rules[i] = normalize.Rule{Pattern: regexp.MustCompile(r.Pattern), Replacement: r.Replacement}
+	}
+	threshold := c.ClusterThreshold
+	if threshold == 0 {
+		threshold = normalize.DefaultThreshold
+	}
+	return normalize.Clusterer{
+		Normalizer:  normalize.Default(rules...),
+		Threshold:   threshold,
+		MaxExamples: normalize.DefaultMaxExamples,
+	}
+}
+
+// fullName returns the repo name in the owner/repo form
+func (r repoConfig) fullName() string {
+	return r.Owner + "/" + r.Repo
+}
+
+// workflow returns the repo named fullName, in the owner/repo form, and its
+// workflow declared with the given file name, if both are in the config
+func (c *config) workflow(fullName, file string) (repoConfig, workflowConfig, bool) {
+	repo, ok := c.repo(fullName)
+	if !ok {
+		return repoConfig{}, workflowConfig{}, false
+	}
+	for _, w := range repo.Workflows {
+		if w.File == file {
+			return repo, w, true
+		}
+	}
+	return repoConfig{}, workflowConfig{}, false
+}
+
+// repo returns the repo named fullName, in the owner/repo form, if it's in
+// the config
+func (c *config) repo(fullName string) (repoConfig, bool) {
+	for _, r := range c.Repos {
+		if r.fullName() == fullName {
+			return r, true
+		}
+	}
+	return repoConfig{}, false
+}
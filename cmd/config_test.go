@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := loadConfig("testdata/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Repos) != 2 {
+		t.Fatalf("expected 2 repos, got %d", len(cfg.Repos))
+	}
+	if name := cfg.Repos[1].fullName(); name != "linkerd/linkerd2-proxy" {
+		t.Errorf("unexpected repo name %s", name)
+	}
+	if w := cfg.Repos[0].Workflows[0]; !w.FetchAnnotations || w.Name != "KinD integration" {
+		t.Errorf("unexpected workflow %+v", w)
+	}
+
+	c := cfg.clusterer()
+	if c.Threshold != 0.9 {
+		t.Errorf("expected a cluster threshold of 0.9, got %v", c.Threshold)
+	}
+	if m := c.Normalizer.Normalize("TestInstall - run 42 timed-out after 30s"); m != "TestInstall - run <n> timed-out after <duration>" {
+		t.Errorf("unexpected normalized message %q", m)
+	}
+
+	cfg, err = loadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("default config is invalid: %s", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	workflow := workflowConfig{File: "ci.yml", Name: "CI"}
+	testCases := []struct {
+		name string
+		cfg  config
+		err  string
+	}{
+		{
+			"no repos",
+			config{},
+			"at least one entry under \"repos\"",
+		},
+		{
+			"missing owner",
+			config{Repos: []repoConfig{{Repo: "linkerd2", Workflows: []workflowConfig{workflow}}}},
+			"repos[0]: \"owner\" is required",
+		},
+		{
+			"duplicate repo",
+			config{Repos: []repoConfig{
+				{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{workflow}},
+				{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{workflow}},
+			}},
+			"repos[1]: linkerd/linkerd2 is declared more than once",
+		},
+		{
+			"no workflows",
+			config{Repos: []repoConfig{{Owner: "linkerd", Repo: "linkerd2"}}},
+			"at least one entry under \"workflows\"",
+		},
+		{
+			"bad workflow file",
+			config{Repos: []repoConfig{{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{{File: "ci", Name: "CI"}}}}},
+			"must be a workflow file name ending in .yml or .yaml",
+		},
+		{
+			"duplicate workflow name",
+			config{Repos: []repoConfig{{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{
+				workflow,
+				{File: "other.yml", Name: "CI"},
+			}}}},
+			"repos[0].workflows[1] (linkerd/linkerd2): workflow name \"CI\" is declared more than once",
+		},
+		{
+			"invalid message rule",
+			config{
+				Repos:        []repoConfig{{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{workflow}}},
+				MessageRules: []messageRule{{Pattern: "run (\\d+", Replacement: "run <n>"}},
+			},
+			"messageRules[0]: invalid pattern",
+		},
+		{
+			"cluster threshold out of range",
+			config{
+				Repos:            []repoConfig{{Owner: "linkerd", Repo: "linkerd2", Workflows: []workflowConfig{workflow}}},
+				ClusterThreshold: 1.5,
+			},
+			"\"clusterThreshold\" must be between 0 and 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.validate()
+			if err == nil {
+				t.Fatalf("expected error containing %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// jobURL returns the URL of the job's page on Github, empty if its check run
+// isn't known. Jobs fetched before their run was recorded link to their check
+// run, which Github redirects to the job's page.
+func jobURL(webURL string, job JobRun) string {
+	switch {
+	case job.CheckRunID == 0:
+		return ""
+	case job.RunID == 0:
+		return fmt.Sprintf("%s/%s/runs/%d", webURL, job.Repo, job.CheckRunID)
+	default:
+		return fmt.Sprintf("%s/%s/actions/runs/%d/job/%d", webURL, job.Repo, job.RunID, job.CheckRunID)
+	}
+}
+
+// formatTime formats t as RFC3339 for the CSV tables, empty if unknown
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// formatSeconds formats d as a number of seconds for the CSV tables
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// formatInt formats n for the CSV tables, empty if zero, which stands for
+// unknown in the data fetched by older versions
+func formatInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// writeCSV writes the header and the rows to out, quoting the fields as
+// needed, e.g. the multi-line error messages
+func writeCSV(out io.Writer, header []string, rows [][]string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// writeJobsCSV writes the jobs of the report r to out as CSV, one row per job
+// run
+func writeJobsCSV(out io.Writer, r *report) error {
+	s := r.snapshot
+	webURL := githubWebURL(s.APIURL)
+	rows := make([][]string, len(s.Jobs))
+	for i, job := range s.Jobs {
+		duration := ""
+		if d, ok := jobDuration(job); ok {
+			duration = formatSeconds(d)
+		}
+		rows[i] = []string{
+			job.Repo,
+			job.Workflow,
+			job.Job,
+			formatInt(job.RunID),
+			formatInt(int64(job.RunAttempt)),
+			formatInt(job.CheckRunID),
+			job.HeadSHA,
+			job.Conclusion,
+			formatTime(job.Started.Time),
+			formatTime(job.Completed.Time),
+			duration,
+			jobURL(webURL, job),
+		}
+	}
+	return writeCSV(out, []string{
+		"repo", "workflow", "job", "run_id", "run_attempt", "check_run_id", "head_sha",
+		"conclusion", "started_at", "completed_at", "duration_seconds", "url",
+	}, rows)
+}
+
+// writeAnnotationsCSV writes the annotations of the report r to out as CSV,
+// one row per annotation, along with the job run it belongs to
+func writeAnnotationsCSV(out io.Writer, r *report) error {
+	s := r.snapshot
+	webURL := githubWebURL(s.APIURL)
+	rows := make([][]string, len(s.Annotations))
+	for i, ann := range s.Annotations {
+		fileURL := ""
+		if ann.Path != "" && ann.Path != runnerPath && ann.HeadSHA != "" {
+			fileURL = blobURL(webURL, ann.Repo, ann.HeadSHA, ann.Path, ann.StartLine, ann.EndLine)
+		}
+		rows[i] = []string{
+			ann.Repo,
+			ann.Workflow,
+			ann.Job,
+			formatInt(ann.RunID),
+			formatInt(int64(ann.RunAttempt)),
+			formatInt(ann.CheckRunID),
+			ann.HeadSHA,
+			ann.Conclusion,
+			formatTime(ann.Started.Time),
+			ann.Path,
+			formatInt(int64(ann.StartLine)),
+			formatInt(int64(ann.EndLine)),
+			ann.Test,
+			ann.Message,
+			jobURL(webURL, ann.JobRun),
+			fileURL,
+		}
+	}
+	return writeCSV(out, []string{
+		"repo", "workflow", "job", "run_id", "run_attempt", "check_run_id", "head_sha",
+		"conclusion", "started_at", "path", "start_line", "end_line", "test", "message",
+		"job_url", "file_url",
+	}, rows)
+}
+
+// totalsColumns are the columns of the aggregated tables holding RunTotals
+// and the median and 90th percentile durations
+var totalsColumns = []string{"runs", "successes", "failures", "success_rate", "p50_duration_seconds", "p90_duration_seconds"}
+
+// totalsRow returns the fields of the totals columns, along with the given
+// durations distribution. The durations are left empty if unknown.
+func totalsRow(t RunTotals, stats DurationStats, ok bool) []string {
+	row := []string{
+		strconv.Itoa(t.Runs),
+		strconv.Itoa(t.Successes),
+		strconv.Itoa(t.Failures),
+		strconv.FormatFloat(t.SuccessRate, 'f', -1, 64),
+		"",
+		"",
+	}
+	if ok {
+		row[4], row[5] = formatSeconds(stats.P50), formatSeconds(stats.P90)
+	}
+	return row
+}
+
+// writeJobTotalsCSV writes the totals of the runs of each job of the report r
+// to out as CSV, sorted by repo, workflow and job
+func writeJobTotalsCSV(out io.Writer, r *report) error {
+	rows := make([][]string, len(r.metrics.Jobs))
+	for i, job := range r.metrics.Jobs {
+		stats, ok := r.jobStats[[3]string{job.Repo, job.Workflow, job.Job}]
+		rows[i] = append([]string{job.Repo, job.Workflow, job.Job}, totalsRow(job.RunTotals, stats, ok)...)
+	}
+	return writeCSV(out, append([]string{"repo", "workflow", "job"}, totalsColumns...), rows)
+}
+
+// writeWorkflowTotalsCSV writes the totals of the job runs of each workflow of
+// the report r to out as CSV, sorted by repo and workflow, along with the
+// durations of the workflow runs and their most frequent error message
+func writeWorkflowTotalsCSV(out io.Writer, r *report) error {
+	rows := make([][]string, len(r.metrics.Workflows))
+	for i, w := range r.metrics.Workflows {
+		topMessage, topMessageCount := "", 0
+		if len(w.Messages) > 0 {
+			topMessage, topMessageCount = w.Messages[0].Message, w.Messages[0].Count
+		}
+		stats, ok := r.workflowStats[[2]string{w.Repo, w.Workflow}]
+		row := append([]string{w.Repo, w.Workflow}, totalsRow(w.RunTotals, stats, ok)...)
+		rows[i] = append(row, topMessage, strconv.Itoa(topMessageCount))
+	}
+	header := append([]string{"repo", "workflow"}, totalsColumns...)
+	return writeCSV(out, append(header, "top_message", "top_message_count"), rows)
+}
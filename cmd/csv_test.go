@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// readCSV calls render, and parses the CSV it writes back into a list of
+// records keyed by column name
+func readCSV(t *testing.T, render func(*bytes.Buffer) error) []map[string]string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := render(&out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]map[string]string, len(rows)-1)
+	for i, row := range rows[1:] {
+		records[i] = make(map[string]string)
+		for j, column := range rows[0] {
+			records[i][column] = row[j]
+		}
+	}
+	return records
+}
+
+func TestWriteCSV(t *testing.T) {
+	start := time.Date(2020, 6, 1, 10, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	job := func(name, conclusion string, runID, checkRunID int64, minutes int) JobRun {
+		return JobRun{
+			Repo:       "linkerd/linkerd2",
+			Workflow:   "CI",
+			Job:        name,
+			CheckRunID: checkRunID,
+			RunID:      runID,
+			RunAttempt: 1,
+			HeadSHA:    "abc123",
+			Conclusion: conclusion,
+			Started:    github.Timestamp{Time: start},
+			Completed:  github.Timestamp{Time: start.Add(time.Duration(minutes) * time.Minute)},
+		}
+	}
+	jobs := []JobRun{
+		job("unit", "success", 1, 10, 3),
+		job("unit", "failure", 2, 20, 5),
+		job("integration", "success", 1, 11, 30),
+		{Repo: "linkerd/linkerd2", Workflow: "Docs", Job: "build", Conclusion: "success"},
+	}
+	s := &snapshot{
+		Jobs: jobs,
+		Annotations: []ErrorAnn{
+			{JobRun: jobs[1], Path: "pkg/unit_test.go", StartLine: 12, EndLine: 14, Test: "TestUnit",
+				Message: "TestUnit - expected \"a\",\ngot \"b\""},
+			{JobRun: jobs[1], Path: runnerPath, Message: "The operation was canceled."},
+		},
+	}
+	r := newReport(s, reportOptions{})
+
+	records := readCSV(t, func(out *bytes.Buffer) error { return writeJobsCSV(out, r) })
+	if len(records) != 4 {
+		t.Fatalf("expected 4 jobs, got %d", len(records))
+	}
+	expected := map[string]string{
+		"job":              "unit",
+		"run_id":           "2",
+		"check_run_id":     "20",
+		"conclusion":       "failure",
+		"started_at":       "2020-06-01T08:00:00Z",
+		"completed_at":     "2020-06-01T08:05:00Z",
+		"duration_seconds": "300",
+		"url":              "https://github.com/linkerd/linkerd2/actions/runs/2/job/20",
+	}
+	for column, value := range expected {
+		if records[1][column] != value {
+			t.Errorf("expected %s to be %q, got %q", column, value, records[1][column])
+		}
+	}
+	// the details that aren't known are left empty
+	for _, column := range []string{"run_id", "check_run_id", "started_at", "duration_seconds", "url"} {
+		if records[3][column] != "" {
+			t.Errorf("expected %s to be empty, got %q", column, records[3][column])
+		}
+	}
+
+	records = readCSV(t, func(out *bytes.Buffer) error { return writeAnnotationsCSV(out, r) })
+	if len(records) != 2 {
+		t.Fatalf("expected 2 annotations, got %d", len(records))
+	}
+	if records[0]["message"] != s.Annotations[0].Message {
+		t.Errorf("expected the multi-line message to be kept, got %q", records[0]["message"])
+	}
+	if url := records[0]["file_url"]; url != "https://github.com/linkerd/linkerd2/blob/abc123/pkg/unit_test.go#L12-L14" {
+		t.Errorf("unexpected file URL %q", url)
+	}
+	if records[1]["file_url"] != "" || records[1]["job_url"] != "https://github.com/linkerd/linkerd2/actions/runs/2/job/20" {
+		t.Errorf("unexpected URLs %+v", records[1])
+	}
+
+	records = readCSV(t, func(out *bytes.Buffer) error { return writeJobTotalsCSV(out, r) })
+	if len(records) != 3 || records[1]["job"] != "unit" || records[1]["runs"] != "2" || records[1]["success_rate"] != "0.5" ||
+		records[1]["p50_duration_seconds"] != "180" || records[1]["p90_duration_seconds"] != "300" {
+		t.Errorf("unexpected job totals %+v", records)
+	}
+
+	records = readCSV(t, func(out *bytes.Buffer) error { return writeWorkflowTotalsCSV(out, r) })
+	if len(records) != 2 {
+		t.Fatalf("expected 2 workflows, got %d", len(records))
+	}
+	// run 1 took as long as its integration job
+	ci := records[0]
+	if ci["workflow"] != "CI" || ci["runs"] != "3" || ci["failures"] != "1" || ci["p90_duration_seconds"] != "1800" ||
+		ci["top_message_count"] != "1" {
+		t.Errorf("unexpected CI totals %+v", ci)
+	}
+	if docs := records[1]; docs["p50_duration_seconds"] != "" || docs["top_message"] != "" || docs["top_message_count"] != "0" {
+		t.Errorf("unexpected Docs totals %+v", docs)
+	}
+}
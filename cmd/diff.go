@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
+)
+
+// successRate holds the number of runs of a workflow or job, and how many of
+// them succeeded
+type successRate struct {
+	Runs      int
+	Successes int
+}
+
+func (r successRate) String() string {
+	if r.Runs == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%% of %d", r.percent(), r.Runs)
+}
+
+func (r successRate) percent() int {
+	return r.Successes * 100 / r.Runs
+}
+
+// rateChange holds the success rates of a workflow or job in two snapshots
+type rateChange struct {
+	Name   string
+	Before successRate
+	After  successRate
+}
+
+// delta returns the change in percentage points, if both snapshots have runs
+func (c rateChange) delta() (int, bool) {
+	if c.Before.Runs == 0 || c.After.Runs == 0 {
+		return 0, false
+	}
+	return c.After.percent() - c.Before.percent(), true
+}
+
+func (c rateChange) deltaString() string {
+	switch {
+	case c.Before.Runs == 0 && c.After.Runs == 0:
+		return "-"
+	case c.Before.Runs == 0:
+		return "new"
+	case c.After.Runs == 0:
+		return "gone"
+	}
+	d, _ := c.delta()
+	return fmt.Sprintf("%+d", d)
+}
+
+// snapshotDiff holds the differences between two snapshots
+type snapshotDiff struct {
+	Before       window
+	After        window
+	Global       rateChange
+	Workflows    []rateChange
+	Jobs         []rateChange
+	NewMessages  pairlist.PairList
+	GoneMessages pairlist.PairList
+}
+
+// diffSnapshots compares the success rates and error messages of two
+// snapshots, the messages being compared once normalized by n. When they cover
+// more than one repo, workflow and job names are prefixed with their repo.
+func diffSnapshots(before, after *snapshot, n normalize.Normalizer) snapshotDiff {
+	allJobs := make([]JobRun, 0, len(before.Jobs)+len(after.Jobs))
+	allJobs = append(allJobs, before.Jobs...)
+	allJobs = append(allJobs, after.Jobs...)
+	qualify := len(getRepos(allJobs)) > 1
+
+	global := func(JobRun) string { return "" }
+	workflow := func(run JobRun) string { return qualifiedName(run.Repo, run.Workflow, qualify) }
+	job := func(run JobRun) string { return qualifiedName(run.Repo, run.Job, qualify) }
+
+	beforeMessages := countMessages(before.Annotations, n)
+	afterMessages := countMessages(after.Annotations, n)
+	return snapshotDiff{
+		Before:       before.Window,
+		After:        after.Window,
+		Global:       rateChange{Before: successRates(before.Jobs, global)[""], After: successRates(after.Jobs, global)[""]},
+		Workflows:    rateChanges(before.Jobs, after.Jobs, workflow),
+		Jobs:         rateChanges(before.Jobs, after.Jobs, job),
+		NewMessages:  missingMessages(afterMessages, beforeMessages),
+		GoneMessages: missingMessages(beforeMessages, afterMessages),
+	}
+}
+
+// successRates returns the success rates of runs grouped by key
+func successRates(runs []JobRun, key func(JobRun) string) map[string]successRate {
+	rates := make(map[string]successRate)
+	for _, run := range runs {
+		r := rates[key(run)]
+		r.Runs++
+		if run.Conclusion == "success" {
+			r.Successes++
+		}
+		rates[key(run)] = r
+	}
+	return rates
+}
+
+// rateChanges returns the success rates of the before and after runs grouped
+// by key, sorted by name
+func rateChanges(before, after []JobRun, key func(JobRun) string) []rateChange {
+	beforeRates := successRates(before, key)
+	afterRates := successRates(after, key)
+	names := make(map[string]struct{})
+	for name := range beforeRates {
+		names[name] = struct{}{}
+	}
+	for name := range afterRates {
+		names[name] = struct{}{}
+	}
+	changes := make([]rateChange, 0, len(names))
+	for name := range names {
+		changes = append(changes, rateChange{name, beforeRates[name], afterRates[name]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// countMessages counts the error messages of the annotations once normalized
+// by n
+func countMessages(annotations []ErrorAnn, n normalize.Normalizer) map[string]int {
+	counts := make(map[string]int)
+	for _, ann := range annotations {
+		counts[n.Normalize(ann.Message)]++
+	}
+	return counts
+}
+
+// missingMessages returns the messages in counts that aren't in other, from
+// more to less frequent
+func missingMessages(counts, other map[string]int) pairlist.PairList {
+	var missing pairlist.PairList
+	for msg, n := range counts {
+		if _, ok := other[msg]; !ok {
+			missing = append(missing, pairlist.Pair{Key: msg, Value: n})
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Value != missing[j].Value {
+			return missing[i].Value > missing[j].Value
+		}
+		return missing[i].Key < missing[j].Key
+	})
+	return missing
+}
+
+// print writes d to out as text, leaving out the workflows and jobs whose
+// success rate changed by threshold points or less
+func (d snapshotDiff) print(out io.Writer, threshold int) error {
+	var table bytes.Buffer
+	tw := tabwriter.NewWriter(&table, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "\tBEFORE\tAFTER\tCHANGE\n")
+	fmt.Fprintf(tw, "Window\t%s\t%s\t\n", formatWindow(d.Before), formatWindow(d.After))
+	fmt.Fprintf(tw, "Success rate\t%s\t%s\t%s\n", d.Global.Before, d.Global.After, d.Global.deltaString())
+	printRateChanges(tw, "Workflows", d.Workflows, threshold)
+	printRateChanges(tw, "Jobs", d.Jobs, threshold)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	// the empty cells of the section titles leave trailing padding
+	for _, line := range strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n") {
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+	}
+
+	printMessages(out, "New error messages", d.NewMessages)
+	printMessages(out, "Error messages gone", d.GoneMessages)
+	return nil
+}
+
+func formatWindow(w window) string {
+	return w.Since.Format(dateFormat) + " - " + w.Until.Format(dateFormat)
+}
+
+func printRateChanges(tw io.Writer, title string, changes []rateChange, threshold int) {
+	fmt.Fprintf(tw, "\t\t\t\n%s:\t\t\t\n", title)
+	shown := 0
+	for _, c := range changes {
+		if d, ok := c.delta(); ok && d <= threshold && d >= -threshold {
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", c.Name, c.Before, c.After, c.deltaString())
+		shown++
+	}
+	if shown == 0 {
+		fmt.Fprintf(tw, "  (no changes)\t\t\t\n")
+	}
+}
+
+func printMessages(out io.Writer, title string, messages pairlist.PairList) {
+	fmt.Fprintf(out, "\n%s:\n", title)
+	if len(messages) == 0 {
+		fmt.Fprintf(out, "  (none)\n")
+	}
+	for _, msg := range messages {
+		fmt.Fprintf(out, "  %5d  %s\n", msg.Value, msg.Key)
+	}
+}
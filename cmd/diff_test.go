@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	before := &snapshot{
+		Jobs: []JobRun{
+			{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", Conclusion: "success"},
+			{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "integration", Conclusion: "success"},
+			{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "integration", Conclusion: "failure"},
+			{Repo: "linkerd/linkerd2", Workflow: "Release", Job: "release", Conclusion: "failure"},
+		},
+		Annotations: []ErrorAnn{
+			{Message: "TestInstall timed-out after 30s"},
+			{Message: "TestUpgrade failed"},
+		},
+	}
+	after := &snapshot{
+		Jobs: []JobRun{
+			{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", Conclusion: "success"},
+			{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "integration", Conclusion: "failure"},
+			{Repo: "linkerd/linkerd2", Workflow: "Lint", Job: "lint", Conclusion: "success"},
+		},
+		Annotations: []ErrorAnn{
+			// the same failure as before, once normalized
+			{Message: "TestInstall timed-out after 45s"},
+			{Message: "TestEgress failed"},
+			{Message: "TestEgress failed"},
+		},
+	}
+
+	d := diffSnapshots(before, after, normalize.Default())
+	if d.Global.Before.percent() != 50 || d.Global.After.percent() != 66 {
+		t.Errorf("unexpected global success rates: %+v", d.Global)
+	}
+	expectedWorkflows := []string{"CI 66% of 3 50% of 2 -16", "Lint - 100% of 1 new", "Release 0% of 1 - gone"}
+	if len(d.Workflows) != len(expectedWorkflows) {
+		t.Fatalf("expected %d workflows, got %+v", len(expectedWorkflows), d.Workflows)
+	}
+	for i, c := range d.Workflows {
+		if got := strings.Join([]string{c.Name, c.Before.String(), c.After.String(), c.deltaString()}, " "); got != expectedWorkflows[i] {
+			t.Errorf("expected %q, got %q", expectedWorkflows[i], got)
+		}
+	}
+	if len(d.NewMessages) != 1 || d.NewMessages[0].Key != "TestEgress failed" || d.NewMessages[0].Value != 2 {
+		t.Errorf("unexpected new messages: %+v", d.NewMessages)
+	}
+	if len(d.GoneMessages) != 1 || d.GoneMessages[0].Key != "TestUpgrade failed" {
+		t.Errorf("unexpected messages gone: %+v", d.GoneMessages)
+	}
+
+	// the unit job didn't change, so it's left out
+	var out bytes.Buffer
+	if err := d.print(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "unit") || !strings.Contains(out.String(), "integration") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func TestDiffSnapshotsWithoutJobs(t *testing.T) {
+	d := diffSnapshots(&snapshot{}, &snapshot{}, normalize.Default())
+	if len(d.Workflows) != 0 || len(d.Jobs) != 0 {
+		t.Errorf("expected no workflows and jobs, got %+v and %+v", d.Workflows, d.Jobs)
+	}
+	if got := d.Global.deltaString(); got != "-" {
+		t.Errorf("expected no global change, got %q", got)
+	}
+
+	var out bytes.Buffer
+	if err := d.print(&out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "new") || strings.Count(out.String(), "(no changes)") != 2 {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// defaultSlowdownThreshold is the default growth of a job's median duration
+// versus the previous window, in percent, above which it's reported as slower
+const defaultSlowdownThreshold = 20
+
+// DurationStats holds the distribution of the durations of the runs of a job
+// or workflow, rounded to the second
+type DurationStats struct {
+	Repo string
+	Name string
+	Runs int
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Mean time.Duration
+	Max  time.Duration
+}
+
+// Slowdown holds the median duration of a job in the previous window and in
+// the current one, which grew by Growth percent
+type Slowdown struct {
+	Repo   string
+	Name   string
+	Before time.Duration
+	After  time.Duration
+	Growth int
+}
+
+// jobDuration returns how long the job took to run, if it's known
+func jobDuration(job JobRun) (time.Duration, bool) {
+	if job.Started.IsZero() || job.Completed.IsZero() || job.Completed.Before(job.Started.Time) {
+		return 0, false
+	}
+	return job.Completed.Sub(job.Started.Time), true
+}
+
+// durationStats returns the distribution of durations, which must not be
+// empty and are left as is. Percentiles follow the nearest-rank method.
+func durationStats(durations []time.Duration) DurationStats {
+	durations = append([]time.Duration(nil), durations...)
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	percentile := func(p int) time.Duration {
+		rank := (p*len(durations) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return durations[rank-1].Round(time.Second)
+	}
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	return DurationStats{
+		Runs: len(durations),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P99:  percentile(99),
+		Mean: (total / time.Duration(len(durations))).Round(time.Second),
+		Max:  durations[len(durations)-1].Round(time.Second),
+	}
+}
+
+// groupDurations returns the duration stats for each group of durations,
+// sorted from slowest to fastest median
+func groupDurations(groups map[[2]string][]time.Duration) []DurationStats {
+	stats := make([]DurationStats, 0, len(groups))
+	for key, durations := range groups {
+		s := durationStats(durations)
+		s.Repo, s.Name = key[0], key[1]
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].P50 != stats[j].P50 {
+			return stats[i].P50 > stats[j].P50
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// getJobDurations returns the duration stats of each job, whatever its
+// workflow, from the durations of the runs of each job as returned by
+// jobRunDurations, from slowest to fastest median. If qualify is true, job
+// names are prefixed with their repo.
+func getJobDurations(durations map[[3]string][]time.Duration, qualify bool) []DurationStats {
+	groups := make(map[[2]string][]time.Duration)
+	for key, d := range durations {
+		group := [2]string{key[0], qualifiedName(key[0], key[2], qualify)}
+		groups[group] = append(groups[group], d...)
+	}
+	return groupDurations(groups)
+}
+
+// getWorkflowDurations returns the duration stats of each workflow from the
+// durations of its runs as returned by workflowRunDurations, from slowest to
+// fastest median. If qualify is true, workflow names are prefixed with their
+// repo.
+func getWorkflowDurations(durations map[[2]string][]time.Duration, qualify bool) []DurationStats {
+	groups := make(map[[2]string][]time.Duration)
+	for key, d := range durations {
+		groups[[2]string{key[0], qualifiedName(key[0], key[1], qualify)}] = d
+	}
+	return groupDurations(groups)
+}
+
+// jobRunDurations returns the durations of the runs of each job, keyed by
+// repo, workflow and job. The jobs whose duration isn't known are left out.
+func jobRunDurations(jobs []JobRun) map[[3]string][]time.Duration {
+	durations := make(map[[3]string][]time.Duration)
+	for _, job := range jobs {
+		if d, ok := jobDuration(job); ok {
+			key := [3]string{job.Repo, job.Workflow, job.Job}
+			durations[key] = append(durations[key], d)
+		}
+	}
+	return durations
+}
+
+// workflowRunDurations returns the durations of the runs of each workflow,
+// keyed by repo and workflow. The duration of a workflow run goes from the
+// start of its first job to the completion of its last one, for each one of
+// its attempts. Jobs not recording their workflow run, as fetched by older
+// versions, are left out.
+func workflowRunDurations(jobs []JobRun) map[[2]string][]time.Duration {
+	type runAttempt struct {
+		repo, workflow string
+		id             int64
+		attempt        int
+	}
+	type span struct{ start, end time.Time }
+	spans := make(map[runAttempt]span)
+	for _, job := range jobs {
+		if _, ok := jobDuration(job); !ok || job.RunID == 0 {
+			continue
+		}
+		key := runAttempt{job.Repo, job.Workflow, job.RunID, job.RunAttempt}
+		s, ok := spans[key]
+		if !ok || job.Started.Before(s.start) {
+			s.start = job.Started.Time
+		}
+		if job.Completed.After(s.end) {
+			s.end = job.Completed.Time
+		}
+		spans[key] = s
+	}
+
+	durations := make(map[[2]string][]time.Duration)
+	for run, s := range spans {
+		key := [2]string{run.repo, run.workflow}
+		durations[key] = append(durations[key], s.end.Sub(s.start))
+	}
+	return durations
+}
+
+// getSlowdowns returns the jobs whose median duration grew by more than
+// threshold percent from the previous durations to the current ones, from
+// larger to smaller growth
+func getSlowdowns(previous, current []DurationStats, threshold int) []Slowdown {
+	before := make(map[[2]string]time.Duration)
+	for _, s := range previous {
+		before[[2]string{s.Repo, s.Name}] = s.P50
+	}
+
+	var slowdowns []Slowdown
+	for _, s := range current {
+		b, ok := before[[2]string{s.Repo, s.Name}]
+		if !ok || b == 0 {
+			continue
+		}
+		growth := int((s.P50 - b) * 100 / b)
+		if growth > threshold {
+			slowdowns = append(slowdowns, Slowdown{Repo: s.Repo, Name: s.Name, Before: b, After: s.P50, Growth: growth})
+		}
+	}
+	sort.Slice(slowdowns, func(i, j int) bool {
+		if slowdowns[i].Growth != slowdowns[j].Growth {
+			return slowdowns[i].Growth > slowdowns[j].Growth
+		}
+		return slowdowns[i].Name < slowdowns[j].Name
+	})
+	return slowdowns
+}
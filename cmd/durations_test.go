@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// timedJob returns a job of the CI workflow run runID, started minutes after
+// start and lasting duration minutes
+func timedJob(name string, runID int64, start time.Time, minutes, duration int) JobRun {
+	started := start.Add(time.Duration(minutes) * time.Minute)
+	return JobRun{
+		Repo:       "linkerd/linkerd2",
+		Workflow:   "CI",
+		Job:        name,
+		RunID:      runID,
+		RunAttempt: 1,
+		Conclusion: "success",
+		Started:    github.Timestamp{Time: started},
+		Completed:  github.Timestamp{Time: started.Add(time.Duration(duration) * time.Minute)},
+	}
+}
+
+func TestDurationStats(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Second)
+	}
+	s := durationStats(durations)
+	if s.Runs != 100 || s.P50 != 50*time.Second || s.P90 != 90*time.Second || s.P99 != 99*time.Second ||
+		s.Max != 100*time.Second || s.Mean != 51*time.Second {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	s = durationStats([]time.Duration{time.Minute})
+	if s.P50 != time.Minute || s.P99 != time.Minute {
+		t.Errorf("unexpected stats for a single duration %+v", s)
+	}
+}
+
+func TestGetDurations(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []JobRun{
+		timedJob("unit", 1, start, 0, 5),
+		timedJob("integration", 1, start, 1, 20),
+		timedJob("unit", 2, start, 60, 7),
+		timedJob("integration", 2, start, 60, 30),
+		timedJob("integration", 3, start, 120, 40),
+	}
+	// not completed
+	jobs = append(jobs, JobRun{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", RunID: 3, Started: github.Timestamp{Time: start}})
+
+	jobDurations := getJobDurations(jobRunDurations(jobs), false)
+	if len(jobDurations) != 2 || jobDurations[0].Name != "integration" || jobDurations[0].P50 != 30*time.Minute ||
+		jobDurations[1].Name != "unit" || jobDurations[1].Runs != 2 {
+		t.Errorf("unexpected job durations %+v", jobDurations)
+	}
+
+	workflowDurations := getWorkflowDurations(workflowRunDurations(jobs), false)
+	if len(workflowDurations) != 1 || workflowDurations[0].Runs != 3 || workflowDurations[0].Max != 40*time.Minute ||
+		workflowDurations[0].P50 != 30*time.Minute {
+		t.Errorf("unexpected workflow durations %+v", workflowDurations)
+	}
+
+	previous := getJobDurations(jobRunDurations([]JobRun{
+		timedJob("unit", 0, start, 0, 5),
+		timedJob("integration", 0, start, 0, 20),
+	}), false)
+	slowdowns := getSlowdowns(previous, jobDurations, defaultSlowdownThreshold)
+	if len(slowdowns) != 1 || slowdowns[0].Name != "integration" || slowdowns[0].Growth != 50 {
+		t.Errorf("unexpected slowdowns %+v", slowdowns)
+	}
+
+	s := &snapshot{Window: window{Since: start, Until: start.Add(24 * time.Hour)}, Jobs: jobs}
+	opts := reportOptions{previous: &snapshot{Jobs: jobs[:2]}, slowdownThreshold: defaultSlowdownThreshold}
+	if err := processData(ioutil.Discard, newReport(s, opts)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,370 @@
+// Package fakegithub provides an in-memory implementation of the Github API
+// endpoints queried when building the report, so that the whole pipeline can
+// be tested offline:
+//
+//	GET /repos/:owner/:repo/actions/workflows/:workflow_name/runs
+//	GET /repos/:owner/:repo/actions/runs/:run_id
+//	GET /repos/:owner/:repo/check-suites/:check_suite_id/check-runs
+//	GET /repos/:owner/:repo/check-runs/:check_run_id/annotations
+//
+// Responses are paginated through the page and per_page query params and the
+// Link header, and carry the X-RateLimit-* headers. Successful responses carry
+// an ETag header, and conditional requests matching it are answered with a 304
+// that doesn't count against the rate limit.
+package fakegithub
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+const (
+	defaultPerPage = 30
+	maxPerPage     = 100
+
+	// DefaultRateLimit is the number of requests per hour allowed by
+	// default, the same as for authenticated Github API requests
+	DefaultRateLimit = 5000
+)
+
+// Server is a fake Github API server. Its URL can be used as the BaseURL of
+// a github.Client.
+type Server struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	workflowRuns map[string][]*github.WorkflowRun
+	runAttempts  map[int64]int
+	checkSuites  map[int64]int64
+	checkRuns    map[string][]*github.CheckRun
+	annotations  map[string][]*github.CheckRunAnnotation
+	limit        int
+	remaining    int
+	reset        time.Time
+	abuses       int
+	retryAfter   time.Duration
+	failures     []int
+	requests     int
+}
+
+// New starts a new fake Github API server. It should be closed after use.
+func New() *Server {
+	s := &Server{
+		workflowRuns: make(map[string][]*github.WorkflowRun),
+		runAttempts:  make(map[int64]int),
+		checkSuites:  make(map[int64]int64),
+		checkRuns:    make(map[string][]*github.CheckRun),
+		annotations:  make(map[string][]*github.CheckRunAnnotation),
+		limit:        DefaultRateLimit,
+		remaining:    DefaultRateLimit,
+		reset:        time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// AddWorkflowRun registers a run for the given workflow file, belonging to
+// checkSuiteID. The run's CheckSuiteURL is set accordingly.
+func (s *Server) AddWorkflowRun(owner, repo, workflowFile string, checkSuiteID int64, run *github.WorkflowRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.CheckSuiteURL = github.String(fmt.Sprintf("%s/repos/%s/%s/check-suites/%d", s.URL, owner, repo, checkSuiteID))
+	s.checkSuites[run.GetID()] = checkSuiteID
+	key := workflowKey(owner, repo, workflowFile)
+	s.workflowRuns[key] = append(s.workflowRuns[key], run)
+}
+
+// SetRunAttempt sets the attempt of the workflow run with the given ID,
+// returned as its run_attempt field, which github.WorkflowRun lacks
+func (s *Server) SetRunAttempt(runID int64, attempt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runAttempts[runID] = attempt
+}
+
+// AddCheckRuns registers check runs (jobs) under checkSuiteID
+func (s *Server) AddCheckRuns(owner, repo string, checkSuiteID int64, runs ...*github.CheckRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := idKey(owner, repo, checkSuiteID)
+	s.checkRuns[key] = append(s.checkRuns[key], runs...)
+}
+
+// AddAnnotations registers annotations for checkRunID
+func (s *Server) AddAnnotations(owner, repo string, checkRunID int64, anns ...*github.CheckRunAnnotation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := idKey(owner, repo, checkRunID)
+	s.annotations[key] = append(s.annotations[key], anns...)
+}
+
+// SetRateLimit sets the number of requests remaining until reset. Once
+// exhausted, requests are answered with a 403 as Github does.
+func (s *Server) SetRateLimit(limit, remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	s.remaining = remaining
+	// Github reports the reset time as a Unix timestamp in seconds
+	s.reset = reset.Truncate(time.Second)
+}
+
+// SetSecondaryRateLimit makes the next n requests fail as if they hit
+// Github's secondary (abuse) rate limit, with a Retry-After header of
+// retryAfter.
+func (s *Server) SetSecondaryRateLimit(n int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.abuses = n
+	s.retryAfter = retryAfter
+}
+
+// FailNext makes the next n requests fail with the given HTTP status code.
+// If status is 0 the connection is closed without sending any response.
+func (s *Server) FailNext(n int, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Requests returns the number of requests served so far
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	if time.Now().After(s.reset) {
+		s.remaining = s.limit
+		s.reset = time.Now().Add(time.Hour).Truncate(time.Second)
+	}
+	if len(s.failures) > 0 {
+		status := s.failures[0]
+		s.failures = s.failures[1:]
+		if status == 0 {
+			if hj, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hj.Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+			}
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+	if s.abuses > 0 {
+		s.abuses--
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message":           "You have triggered an abuse detection mechanism. Please wait a few minutes before you try again.",
+			"documentation_url": "https://developer.github.com/v3/#abuse-rate-limits",
+		})
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	s.route(rec, r)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.limit))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(s.reset.Unix(), 10))
+
+	// conditional requests answered with a 304 don't count against the
+	// rate limit
+	if rec.Code == http.StatusOK {
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(rec.Body.Bytes()))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	exhausted := s.remaining == 0
+	if !exhausted {
+		s.remaining--
+	}
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+	if exhausted {
+		writeError(w, http.StatusForbidden, "API rate limit exceeded")
+		return
+	}
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+// route writes the response for the endpoint requested by r
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// repos/:owner/:repo/...
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 5 || parts[0] != "repos" {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	owner, repo := parts[1], parts[2]
+	switch {
+	case len(parts) == 7 && parts[3] == "actions" && parts[4] == "workflows" && parts[6] == "runs":
+		runs := append([]*github.WorkflowRun(nil), s.workflowRuns[workflowKey(owner, repo, parts[5])]...)
+		// most recent first, as Github does
+		sort.SliceStable(runs, func(i, j int) bool {
+			return runs[i].GetCreatedAt().After(runs[j].GetCreatedAt().Time)
+		})
+		items := make([]interface{}, len(runs))
+		for i, run := range runs {
+			items[i] = s.workflowRun(run, parts[5])
+		}
+		paginate(w, r, items, func(page []interface{}) interface{} {
+			return map[string]interface{}{"total_count": len(items), "workflow_runs": page}
+		})
+	case len(parts) == 6 && parts[3] == "actions" && parts[4] == "runs":
+		id, err := strconv.ParseInt(parts[5], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		prefix := workflowKey(owner, repo, "")
+		for key, runs := range s.workflowRuns {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			for _, run := range runs {
+				if run.GetID() == id {
+					json.NewEncoder(w).Encode(s.workflowRun(run, strings.TrimPrefix(key, prefix)))
+					return
+				}
+			}
+		}
+		writeError(w, http.StatusNotFound, "Not Found")
+	case len(parts) == 6 && parts[3] == "check-suites" && parts[5] == "check-runs":
+		id, err := strconv.ParseInt(parts[4], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		status := r.URL.Query().Get("status")
+		var items []interface{}
+		for _, run := range s.checkRuns[idKey(owner, repo, id)] {
+			if status != "" && run.Status != nil && run.GetStatus() != status {
+				continue
+			}
+			items = append(items, run)
+		}
+		paginate(w, r, items, func(page []interface{}) interface{} {
+			return map[string]interface{}{"total_count": len(items), "check_runs": page}
+		})
+	case len(parts) == 6 && parts[3] == "check-runs" && parts[5] == "annotations":
+		id, err := strconv.ParseInt(parts[4], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		anns := s.annotations[idKey(owner, repo, id)]
+		items := make([]interface{}, len(anns))
+		for i, ann := range anns {
+			items[i] = ann
+		}
+		paginate(w, r, items, func(page []interface{}) interface{} {
+			return page
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// workflowRun returns run as served by Github, with the fields that
+// github.WorkflowRun lacks
+func (s *Server) workflowRun(run *github.WorkflowRun, workflowFile string) interface{} {
+	return struct {
+		*github.WorkflowRun
+		RunAttempt   int    `json:"run_attempt,omitempty"`
+		Path         string `json:"path"`
+		CheckSuiteID int64  `json:"check_suite_id"`
+	}{run, s.runAttempts[run.GetID()], ".github/workflows/" + workflowFile, s.checkSuites[run.GetID()]}
+}
+
+// paginate writes the page of items requested by r, wrapped by the wrap
+// function, and sets the Link header pointing to the next and last pages
+func paginate(w http.ResponseWriter, r *http.Request, items []interface{}, wrap func([]interface{}) interface{}) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	start := (page - 1) * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+	if end < len(items) {
+		lastPage := (len(items) + perPage - 1) / perPage
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next", <%s>; rel="last"`,
+			pageURL(r, page+1), pageURL(r, lastPage)))
+	}
+
+	pageItems := items[start:end]
+	if pageItems == nil {
+		pageItems = []interface{}{}
+	}
+	json.NewEncoder(w).Encode(wrap(pageItems))
+}
+
+func pageURL(r *http.Request, page int) string {
+	u := *r.URL
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf("http://%s%s", r.Host, u.RequestURI())
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":           message,
+		"documentation_url": "https://developer.github.com/v3",
+	})
+}
+
+func workflowKey(owner, repo, workflowFile string) string {
+	return owner + "/" + repo + "/" + workflowFile
+}
+
+func idKey(owner, repo string, id int64) string {
+	return fmt.Sprintf("%s/%s/%d", owner, repo, id)
+}
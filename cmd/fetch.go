@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/ratelimit"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// the Github API allows 5000 requests/hour for authenticated requests
+	defaultRequestsPerHour = 5000
+	defaultConcurrency     = 8
+	rateLimitBurst         = 10
+
+	// time waited after hitting the secondary rate limit, when Github
+	// doesn't provide a Retry-After header
+	defaultRetryAfter = time.Minute
+	// margin added to the rate limit reset time, to account for clock skew
+	// and the reset time having a resolution of one second
+	resetMargin = time.Second
+	// timeout for a single request to the Github API
+	requestTimeout = time.Minute
+)
+
+// fetcher retrieves jobs and annotations from the Github API. Requests are
+// sent concurrently by at most concurrency goroutines at a time, all of them
+// sharing the same rate limiter. If store is set, only the data not already
+// stored is fetched.
+type fetcher struct {
+	client      *github.Client
+	limiter     *ratelimit.Limiter
+	sem         chan struct{}
+	maxAttempts int
+	baseBackoff time.Duration
+	store       *store
+	window      window
+}
+
+// newFetcher returns a fetcher using client, sending at most concurrency
+// requests at a time and no more than requestsPerHour requests per hour, and
+// trying each request up to maxAttempts times. It fetches the jobs of the last
+// month, unless its window is changed.
+func newFetcher(client *github.Client, concurrency, requestsPerHour, maxAttempts int) *fetcher {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &fetcher{
+		client:      client,
+		limiter:     ratelimit.PerHour(requestsPerHour, rateLimitBurst),
+		sem:         make(chan struct{}, concurrency),
+		maxAttempts: maxAttempts,
+		baseBackoff: defaultBaseBackoff,
+		window:      defaultWindow(time.Now()),
+	}
+}
+
+// newClient returns a Github client authenticated with token, sending its
+// requests to the API at baseURL through the base transport
+// (http.DefaultTransport if nil)
+func newClient(token, baseURL string, base http.RoundTripper) (*github.Client, error) {
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := &http.Client{
+		Transport: &oauth2.Transport{Source: ts, Base: base},
+		Timeout:   requestTimeout,
+	}
+
+	c := github.NewClient(tc)
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Github API URL %q: %s", baseURL, err)
+	}
+	c.BaseURL = u
+	return c, nil
+}
+
+// do runs the given Github API call once a request slot is available and the
+// rate limiter allows it. The pace of the rate limiter is adapted to the budget
+// reported by each response. When the rate limit is hit, all the requests are
+// held until the limit is reset, and the call is retried. Transient failures
+// are retried with exponential backoff, up to maxAttempts times.
+func (f *fetcher) do(ctx context.Context, call func() (*github.Response, error)) (*github.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := f.doOnce(ctx, call)
+		if resp != nil && !resp.Rate.Reset.IsZero() {
+			if interval, changed := f.limiter.Adapt(resp.Rate.Remaining, resp.Rate.Reset.Time); changed {
+				log.Printf("pacing requests at one every %s (%d requests remaining until %s)",
+					interval, resp.Rate.Remaining, resp.Rate.Reset.Format(time.RFC3339))
+			}
+		}
+		if err == nil || ctx.Err() != nil {
+			return resp, err
+		}
+
+		// waiting for the rate limit to reset doesn't count as an attempt
+		if wait, ok := primaryRateLimitWait(err); ok {
+			log.Printf("%s; holding requests for %s", err, wait.Round(time.Second))
+			f.limiter.PauseUntil(time.Now().Add(wait))
+			attempt--
+			continue
+		}
+
+		if !isRetryable(resp, err) {
+			return resp, err
+		}
+		if attempt >= f.maxAttempts {
+			return resp, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := backoff(f.baseBackoff, attempt)
+		if retryAfter, ok := secondaryRateLimitWait(resp, err); ok {
+			// the secondary rate limit applies to all the requests
+			f.limiter.PauseUntil(time.Now().Add(retryAfter))
+			if retryAfter > wait {
+				wait = retryAfter
+			}
+		}
+		log.Printf("%s; retrying in %s (attempt %d/%d)", err, wait.Round(time.Millisecond), attempt+1, f.maxAttempts)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// doOnce runs the given Github API call once a request slot is available
+// and the rate limiter allows it
+func (f *fetcher) doOnce(ctx context.Context, call func() (*github.Response, error)) (*github.Response, error) {
+	select {
+	case f.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-f.sem }()
+
+	if err := f.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	return call()
+}
+
+// forEach calls fn concurrently for every index in [0, n), returning the
+// first error found. The context passed to fn is canceled as soon as one of
+// the calls fails.
+func forEach(ctx context.Context, n int, fn func(context.Context, int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// getAnnotations returns the list of annotations for the job's check run.
+// Generic annotations with messages like "Process completed with exit code #"
+// are not considered, neither are the jobs that were canceled because a sibling
+// job didn't complete successfully.
+func (f *fetcher) getAnnotations(ctx context.Context, repo repoConfig, job JobRun) ([]ErrorAnn, error) {
+	var annotations []*github.CheckRunAnnotation
+	opt := optBigListPage
+	for {
+		var page []*github.CheckRunAnnotation
+		resp, err := f.do(ctx, func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			page, resp, err = f.client.Checks.ListCheckRunAnnotations(ctx, repo.Owner, repo.Repo, job.CheckRunID, &opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, describeError(err, fmt.Sprintf("fetching annotations for check run %d (job %q of workflow %q in %s)",
+				job.CheckRunID, job.Job, job.Workflow, repo.fullName()))
+		}
+		annotations = append(annotations, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	var errorAnns []ErrorAnn
+	for _, ann := range annotations {
+		if strings.Contains(ann.GetMessage(), "Process completed with exit code") ||
+			strings.Contains(ann.GetMessage(), "The job was canceled") {
+			continue
+		}
+		errorAnn := ErrorAnn{
+			JobRun:    job,
+			Path:      ann.GetPath(),
+			StartLine: ann.GetStartLine(),
+			EndLine:   ann.GetEndLine(),
+			Message:   ann.GetMessage(),
+		}
+		errorAnn.Test, errorAnn.Detail = parseTestMessage(errorAnn.Message)
+		errorAnns = append(errorAnns, errorAnn)
+	}
+	return errorAnns, nil
+}
+
+// getJobsAnnotations returns the annotations for all the jobs, fetched
+// concurrently but returned in the same order as the jobs
+func (f *fetcher) getJobsAnnotations(ctx context.Context, repo repoConfig, jobs []JobRun) ([]ErrorAnn, error) {
+	results := make([][]ErrorAnn, len(jobs))
+	err := forEach(ctx, len(jobs), func(ctx context.Context, i int) error {
+		anns, err := f.getAnnotations(ctx, repo, jobs[i])
+		results[i] = anns
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []ErrorAnn
+	for _, anns := range results {
+		annotations = append(annotations, anns...)
+	}
+	return annotations, nil
+}
+
+// workflowRun is a github.WorkflowRun along with the fields that go-github
+// doesn't know about. Path is the path of the workflow file in the repo.
+type workflowRun struct {
+	*github.WorkflowRun
+	RunAttempt   int    `json:"run_attempt,omitempty"`
+	Path         string `json:"path,omitempty"`
+	CheckSuiteID int64  `json:"check_suite_id,omitempty"`
+}
+
+// workflowRuns is a page of the workflow runs list
+type workflowRuns struct {
+	TotalCount   int           `json:"total_count"`
+	WorkflowRuns []workflowRun `json:"workflow_runs"`
+}
+
+// listWorkflowRuns returns a page of the runs of workflow in repo, most recent
+// first. It replaces go-github's ListWorkflowRunsByFileName, which drops the
+// runs' attempt.
+func (f *fetcher) listWorkflowRuns(ctx context.Context, repo repoConfig, workflow workflowConfig, opt github.ListOptions) (*workflowRuns, *github.Response, error) {
+	u := fmt.Sprintf("repos/%s/%s/actions/workflows/%s/runs", repo.Owner, repo.Repo, url.PathEscape(workflow.File))
+	query := url.Values{}
+	if opt.Page != 0 {
+		query.Set("page", strconv.Itoa(opt.Page))
+	}
+	if opt.PerPage != 0 {
+		query.Set("per_page", strconv.Itoa(opt.PerPage))
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := f.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	runs := &workflowRuns{}
+	resp, err := f.client.Do(ctx, req, runs)
+	if err != nil {
+		return nil, resp, err
+	}
+	return runs, resp, nil
+}
+
+// getWorkflowRun returns the run of a workflow in repo with the given ID
+func (f *fetcher) getWorkflowRun(ctx context.Context, repo repoConfig, runID int64) (*workflowRun, error) {
+	u := fmt.Sprintf("repos/%s/%s/actions/runs/%d", repo.Owner, repo.Repo, runID)
+	req, err := f.client.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	run := &workflowRun{}
+	_, err = f.do(ctx, func() (*github.Response, error) {
+		return f.client.Do(ctx, req, run)
+	})
+	if err != nil {
+		return nil, describeError(err, fmt.Sprintf("fetching workflow run %d in %s", runID, repo.fullName()))
+	}
+	return run, nil
+}
+
+// getCheckRuns returns all the completed check runs for checkSuiteID, walking
+// through all the result pages
+func (f *fetcher) getCheckRuns(ctx context.Context, repo repoConfig, checkSuiteID int64, workflow workflowConfig) ([]*github.CheckRun, error) {
+	var checkRuns []*github.CheckRun
+	opt := &github.ListCheckRunsOptions{Status: &completed, Filter: &all, ListOptions: optBigListPage}
+	for {
+		var results *github.ListCheckRunsResults
+		resp, err := f.do(ctx, func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			results, resp, err = f.client.Checks.ListCheckRunsCheckSuite(ctx, repo.Owner, repo.Repo, checkSuiteID, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, describeError(err, fmt.Sprintf("fetching check runs for check suite %d (workflow %q in %s)",
+				checkSuiteID, workflow.Name, repo.fullName()))
+		}
+		checkRuns = append(checkRuns, results.CheckRuns...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	return checkRuns, nil
+}
+
+// getJobRuns returns the list of jobs for the given checkSuiteID of the run of
+// workflow in repo. Only the workflows that have been completed, haven't been
+// cancelled and started after since are returned. The second argument returns
+// true if there are more result pages available.
+func (f *fetcher) getJobRuns(ctx context.Context, repo repoConfig, run workflowRun, checkSuiteID int64, workflow workflowConfig, since time.Time) ([]JobRun, bool, error) {
+	checkRuns, err := f.getCheckRuns(ctx, repo, checkSuiteID, workflow)
+	if err != nil {
+		return nil, false, err
+	}
+	attempts := jobAttempts(checkRuns, run.RunAttempt)
+	// nextPage will be true if at least one job started after since.
+	// Invalid workflows will have no jobs ran; for them nextPage is
+	// true so that we still fetch the following page
+	nextPage := len(checkRuns) == 0
+	var jobs []JobRun
+	for _, checkRun := range checkRuns {
+		nextPage = nextPage || !checkRun.GetStartedAt().Before(since)
+		if checkRun.GetConclusion() == "cancelled" {
+			continue
+		}
+
+		jobs = append(jobs, JobRun{
+			Repo:       repo.fullName(),
+			Workflow:   workflow.Name,
+			Job:        checkRun.GetName(),
+			CheckRunID: checkRun.GetID(),
+			RunID:      run.GetID(),
+			RunAttempt: attempts[checkRun.GetID()],
+			HeadSHA:    run.GetHeadSHA(),
+			Conclusion: checkRun.GetConclusion(),
+			Started:    checkRun.GetStartedAt(),
+			Completed:  checkRun.GetCompletedAt(),
+		})
+	}
+
+	if !nextPage {
+		return nil, false, nil
+	}
+
+	return jobs, true, nil
+}
+
+// jobAttempts returns the attempt of each one of the check runs of a workflow
+// run, keyed by check run ID. Github only lists the latest attempt of a
+// workflow run, but the check runs of the previous attempts remain in its
+// check suite, so the attempt of a check run is its rank among the check runs
+// of the same job, capped by the run's attempt when known.
+func jobAttempts(checkRuns []*github.CheckRun, runAttempt int) map[int64]int {
+	sorted := append([]*github.CheckRun(nil), checkRuns...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].GetStartedAt().Time.Equal(sorted[j].GetStartedAt().Time) {
+			return sorted[i].GetStartedAt().Before(sorted[j].GetStartedAt().Time)
+		}
+		return sorted[i].GetID() < sorted[j].GetID()
+	})
+
+	attempts := make(map[int64]int, len(sorted))
+	ranks := make(map[string]int)
+	for _, checkRun := range sorted {
+		ranks[checkRun.GetName()]++
+		attempt := ranks[checkRun.GetName()]
+		if runAttempt > 0 && attempt > runAttempt {
+			attempt = runAttempt
+		}
+		attempts[checkRun.GetID()] = attempt
+	}
+	return attempts
+}
+
+// getData builds the list of jobs and annotations for the repos and workflows
+// declared in cfg, calling the Github API. Workflows are fetched concurrently,
+// but the results follow the order in which they're declared in cfg.
+func (f *fetcher) getData(ctx context.Context, cfg *config) ([]JobRun, []ErrorAnn, error) {
+	type target struct {
+		repo     repoConfig
+		workflow workflowConfig
+	}
+	var targets []target
+	for _, repo := range cfg.Repos {
+		for _, workflow := range repo.Workflows {
+			targets = append(targets, target{repo, workflow})
+		}
+	}
+
+	jobResults := make([][]JobRun, len(targets))
+	annResults := make([][]ErrorAnn, len(targets))
+	err := forEach(ctx, len(targets), func(ctx context.Context, i int) error {
+		var err error
+		jobResults[i], annResults[i], err = f.getWorkflowData(ctx, targets[i].repo, targets[i].workflow)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var jobs []JobRun
+	var annotations []ErrorAnn
+	for i := range targets {
+		jobs = append(jobs, jobResults[i]...)
+		annotations = append(annotations, annResults[i]...)
+	}
+	return jobs, annotations, nil
+}
+
+// getWorkflowData returns the jobs and annotations of the runs of the given
+// workflow in repo that started within the fetcher's window. The check suites
+// in each page of workflow runs are fetched concurrently, and the annotations
+// are fetched only for the jobs that made it into the report.
+//
+// When a store is used, only the runs created after the workflow's watermark
+// are looked at, unless the window starts before the oldest window fetched
+// into the store. Annotations are fetched only for the jobs not yet stored,
+// and the returned data is taken from the store once updated.
+func (f *fetcher) getWorkflowData(ctx context.Context, repo repoConfig, workflow workflowConfig) ([]JobRun, []ErrorAnn, error) {
+	since := f.window.Since
+	if f.store != nil {
+		if watermark, ok := f.store.watermark(repo, workflow, f.window.Since); ok {
+			since = watermark
+		}
+	}
+
+	var jobs []JobRun
+	var annotations []ErrorAnn
+	// the next watermark is the creation time of the oldest run that wasn't
+	// completed yet or, if all were, of the most recent run
+	var newestRun, oldestIncompleteRun time.Time
+	opt := optBigListPage
+	for {
+		var runs *workflowRuns
+		resp, err := f.do(ctx, func() (*github.Response, error) {
+			var resp *github.Response
+			var err error
+			runs, resp, err = f.listWorkflowRuns(ctx, repo, workflow, opt)
+			return resp, err
+		})
+		if err != nil {
+			return nil, nil, describeError(err, fmt.Sprintf("fetching runs of workflow %q (%s) in %s",
+				workflow.Name, workflow.File, repo.fullName()))
+		}
+
+		// runs last updated before since have no jobs that started after
+		// it, and neither have the older ones; they're left out before
+		// fetching their check suites, as the first suite without jobs
+		// within the window ends the fetch anyway
+		done := false
+		var checkSuiteIDs []int64
+		var suiteRuns []workflowRun
+		for _, run := range runs.WorkflowRuns {
+			if run.GetConclusion() == "cancelled" {
+				continue
+			}
+			if updated := run.GetUpdatedAt().Time; !updated.IsZero() && updated.Before(since) {
+				done = true
+				break
+			}
+			url := run.GetCheckSuiteURL()
+			checkSuiteIDstr := url[strings.LastIndex(url, "/")+1:]
+			checkSuiteID, err := strconv.ParseInt(checkSuiteIDstr, 10, 64)
+			if err != nil {
+				continue
+			}
+			checkSuiteIDs = append(checkSuiteIDs, checkSuiteID)
+			suiteRuns = append(suiteRuns, run)
+		}
+
+		jobResults := make([][]JobRun, len(checkSuiteIDs))
+		nextPages := make([]bool, len(checkSuiteIDs))
+		err = forEach(ctx, len(checkSuiteIDs), func(ctx context.Context, i int) error {
+			var err error
+			jobResults[i], nextPages[i], err = f.getJobRuns(ctx, repo, suiteRuns[i], checkSuiteIDs[i], workflow, since)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// stop at the first suite that has no jobs within the window
+		var pageJobs []JobRun
+		for i := range checkSuiteIDs {
+			if !nextPages[i] {
+				done = true
+				break
+			}
+			for _, job := range jobResults[i] {
+				// without a store, jobs out of the window are discarded
+				// right away; with a store they're kept for the reports
+				// covering other windows
+				if f.store == nil && !f.window.contains(job.Started.Time) {
+					continue
+				}
+				if f.store == nil || !f.store.hasJob(job.CheckRunID) {
+					pageJobs = append(pageJobs, job)
+				}
+			}
+
+			created := suiteRuns[i].GetCreatedAt().Time
+			if created.After(newestRun) {
+				newestRun = created
+			}
+			if suiteRuns[i].GetStatus() != completed &&
+				(oldestIncompleteRun.IsZero() || created.Before(oldestIncompleteRun)) {
+				oldestIncompleteRun = created
+			}
+		}
+
+		if workflow.FetchAnnotations {
+			pageAnnotations, err := f.getJobsAnnotations(ctx, repo, pageJobs)
+			if err != nil {
+				return nil, nil, err
+			}
+			annotations = append(annotations, pageAnnotations...)
+		}
+		jobs = append(jobs, pageJobs...)
+
+		if done || resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+
+	if f.store == nil {
+		return jobs, annotations, nil
+	}
+	watermark := newestRun
+	if !oldestIncompleteRun.IsZero() {
+		watermark = oldestIncompleteRun
+	}
+	if err := f.store.add(repo, workflow, f.window.Since, watermark, jobs, annotations); err != nil {
+		return nil, nil, err
+	}
+	jobs, annotations = f.store.workflowData(repo, workflow, f.window)
+	return jobs, annotations, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/fakegithub"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/httpcache"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+)
+
+// setupFakeGithub starts a fake Github API server and returns a fetcher
+// pointing to it
+func setupFakeGithub(t *testing.T) (*fakegithub.Server, *fetcher) {
+	server := fakegithub.New()
+	t.Cleanup(server.Close)
+	// a budget big enough for requests not to be paced
+	server.SetRateLimit(1000000, 1000000, time.Now().Add(time.Minute))
+
+	client, err := newClient("token", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFetcher(client, defaultConcurrency, 3600*1000, 3)
+	f.baseBackoff = time.Millisecond
+	return server, f
+}
+
+func TestGetJobRunsPagination(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	started := github.Timestamp{Time: time.Now()}
+	for i := 1; i <= 250; i++ {
+		server.AddCheckRuns("linkerd", "linkerd2", 1, &github.CheckRun{
+			ID:          github.Int64(int64(i)),
+			Name:        github.String(fmt.Sprintf("job %d", i)),
+			Status:      github.String("completed"),
+			Conclusion:  github.String("failure"),
+			StartedAt:   &started,
+			CompletedAt: &started,
+		})
+	}
+	for i := 0; i < 150; i++ {
+		server.AddAnnotations("linkerd", "linkerd2", 1, &github.CheckRunAnnotation{
+			Path:    github.String("test/install_test.go"),
+			Message: github.String(fmt.Sprintf("TestInstall - error %d", i)),
+		})
+	}
+
+	repo := repoConfig{Owner: "linkerd", Repo: "linkerd2"}
+	workflow := workflowConfig{File: "ci.yml", Name: "CI", FetchAnnotations: true}
+	jobs, _, err := f.getJobRuns(context.Background(), repo, workflowRun{WorkflowRun: &github.WorkflowRun{ID: github.Int64(1)}}, 1, workflow, f.window.Since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 250 {
+		t.Errorf("expected 250 jobs, got %d", len(jobs))
+	}
+	anns, err := f.getJobsAnnotations(context.Background(), repo, jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anns) != 150 {
+		t.Errorf("expected 150 annotations, got %d", len(anns))
+	}
+}
+
+// addWorkflowRuns registers in server n runs of the ci.yml workflow, one per
+// hour going backwards from now, using addWorkflowRun
+func addWorkflowRuns(server *fakegithub.Server, n int) {
+	now := time.Now()
+	for i := int64(1); i <= int64(n); i++ {
+		addWorkflowRun(server, i, now.Add(-time.Duration(i)*time.Hour))
+	}
+}
+
+// addWorkflowRun registers in server the run i of the ci.yml workflow, with a
+// successful and a failed job, the latter with one annotation
+func addWorkflowRun(server *fakegithub.Server, i int64, createdAt time.Time) {
+	created := github.Timestamp{Time: createdAt}
+	server.AddWorkflowRun("linkerd", "linkerd2", "ci.yml", i, &github.WorkflowRun{
+		ID:         github.Int64(100 + i),
+		HeadSHA:    github.String(fmt.Sprintf("sha%d", i)),
+		Status:     github.String("completed"),
+		Conclusion: github.String("failure"),
+		CreatedAt:  &created,
+		UpdatedAt:  &created,
+	})
+	server.AddCheckRuns("linkerd", "linkerd2", i,
+		&github.CheckRun{
+			ID:          github.Int64(10 * i),
+			Name:        github.String("unit tests"),
+			Status:      github.String("completed"),
+			Conclusion:  github.String("success"),
+			StartedAt:   &created,
+			CompletedAt: &created,
+		},
+		&github.CheckRun{
+			ID:          github.Int64(10*i + 1),
+			Name:        github.String("integration tests"),
+			Status:      github.String("completed"),
+			Conclusion:  github.String("failure"),
+			StartedAt:   &created,
+			CompletedAt: &created,
+		},
+	)
+	server.AddAnnotations("linkerd", "linkerd2", 10*i+1,
+		&github.CheckRunAnnotation{Message: github.String(fmt.Sprintf("TestInstall - run %d timed-out", i))},
+		&github.CheckRunAnnotation{Message: github.String("Process completed with exit code 1.")},
+	)
+}
+
+var testConfig = &config{Repos: []repoConfig{{
+	Owner:     "linkerd",
+	Repo:      "linkerd2",
+	Workflows: []workflowConfig{{File: "ci.yml", Name: "CI", FetchAnnotations: true}},
+}}}
+
+func TestGetData(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 3)
+
+	jobs, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 6 {
+		t.Errorf("expected 6 jobs, got %d", len(jobs))
+	}
+	if len(annotations) != 3 {
+		t.Errorf("expected 3 annotations, got %d", len(annotations))
+	}
+	for _, ann := range annotations {
+		if ann.Repo != "linkerd/linkerd2" || ann.Workflow != "CI" || ann.Job != "integration tests" {
+			t.Errorf("unexpected annotation %+v", ann)
+		}
+	}
+	if err := processData(ioutil.Discard, newReport(&snapshot{Window: f.window, Jobs: jobs, Annotations: annotations}, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDataWindow(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 10)
+	now := time.Now()
+	f.window = window{Since: now.Add(-5*time.Hour - 30*time.Minute), Until: now.Add(-2*time.Hour - 30*time.Minute)}
+
+	jobs, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the runs 3, 4 and 5 are within the window
+	if len(jobs) != 6 {
+		t.Errorf("expected 6 jobs, got %d", len(jobs))
+	}
+	for _, job := range jobs {
+		if !f.window.contains(job.Started.Time) {
+			t.Errorf("job %d started at %s, out of the window", job.CheckRunID, job.Started)
+		}
+	}
+	if len(annotations) != 3 {
+		t.Errorf("expected 3 annotations, got %d", len(annotations))
+	}
+}
+
+func TestGetDataRunAttempts(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRun(server, 1, time.Now().Add(-time.Hour))
+	// the integration tests were re-run and passed
+	rerun := github.Timestamp{Time: time.Now().Add(-30 * time.Minute)}
+	server.AddCheckRuns("linkerd", "linkerd2", 1, &github.CheckRun{
+		ID:          github.Int64(12),
+		Name:        github.String("integration tests"),
+		Status:      github.String("completed"),
+		Conclusion:  github.String("success"),
+		StartedAt:   &rerun,
+		CompletedAt: &rerun,
+	})
+	server.SetRunAttempt(101, 2)
+
+	jobs, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := make(map[int64]int)
+	for _, job := range jobs {
+		if job.RunID != 101 || job.HeadSHA != "sha1" {
+			t.Errorf("unexpected run for job %+v", job)
+		}
+		attempts[job.CheckRunID] = job.RunAttempt
+	}
+	expected := map[int64]int{10: 1, 11: 1, 12: 2}
+	if !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("expected attempts %v, got %v", expected, attempts)
+	}
+
+	flaky := getFlakyJobs(jobs)
+	if len(flaky) != 1 || flaky[0].Name != "integration tests" || flaky[0].Score != 100 {
+		t.Errorf("expected the integration tests to be flaky, got %+v", flaky)
+	}
+	if flaky := getFlakyMessages(jobs, annotations, normalize.Default()); len(flaky) != 1 || flaky[0].Name != "TestInstall - run 1 timed-out" {
+		t.Errorf("expected TestInstall to be flaky, got %+v", flaky)
+	}
+}
+
+func TestGetDataDeterministicOrder(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 40)
+
+	var previous []ErrorAnn
+	for i := 0; i < 3; i++ {
+		_, annotations, err := f.getData(context.Background(), testConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(annotations) != 40 {
+			t.Fatalf("expected 40 annotations, got %d", len(annotations))
+		}
+		// runs are listed from most to least recent
+		for j, ann := range annotations {
+			if expected := fmt.Sprintf("TestInstall - run %d timed-out", j+1); ann.Message != expected {
+				t.Fatalf("expected annotation %d to be %q, got %q", j, expected, ann.Message)
+			}
+			if expected := fmt.Sprintf("run %d timed-out", j+1); ann.Test != "TestInstall" || ann.Detail != expected {
+				t.Fatalf("expected annotation %d to have test TestInstall and detail %q, got %q and %q", j, expected, ann.Test, ann.Detail)
+			}
+		}
+		if previous != nil && !reflect.DeepEqual(previous, annotations) {
+			t.Fatal("results differ between runs")
+		}
+		previous = annotations
+	}
+}
+
+func TestGetDataRateLimitExhausted(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	server.SetRateLimit(fakegithub.DefaultRateLimit, 0, time.Now().Add(time.Second))
+
+	start := time.Now()
+	jobs, _, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("expected 2 jobs, got %d", len(jobs))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for the rate limit to reset, waited %s", elapsed)
+	}
+}
+
+func TestGetDataSecondaryRateLimit(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	server.SetSecondaryRateLimit(1, time.Second)
+
+	start := time.Now()
+	_, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 1 {
+		t.Errorf("expected 1 annotation, got %d", len(annotations))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for the Retry-After period, waited %s", elapsed)
+	}
+}
+
+func TestGetDataRetries(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	server.FailNext(1, http.StatusBadGateway)
+	server.FailNext(1, 0)
+
+	jobs, _, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("expected 2 jobs, got %d", len(jobs))
+	}
+}
+
+func TestGetDataRetriesExhausted(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	server.FailNext(3, http.StatusInternalServerError)
+
+	_, _, err := f.getData(context.Background(), testConfig)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "giving up after 3 attempts") {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if requests := server.Requests(); requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestGetDataFailFast(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	cfg := &config{Repos: []repoConfig{{
+		Owner:     "linkerd",
+		Repo:      "linkerd2",
+		Workflows: []workflowConfig{{File: "missing.yml", Name: "Missing"}},
+	}}}
+	server.FailNext(1, http.StatusNotFound)
+
+	_, _, err := f.getData(context.Background(), cfg)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), `fetching runs of workflow "Missing" (missing.yml) in linkerd/linkerd2`) {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if requests := server.Requests(); requests != 1 {
+		t.Errorf("expected a single request, got %d", requests)
+	}
+}
+
+func TestGetDataCache(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 3)
+	cache, err := httpcache.New(t.TempDir(), 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.client, err = newClient("token", server.URL, cache); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missed := cache.Stats().Misses
+
+	// nothing changed in between, so every request is revalidated with a 304
+	second, _, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same jobs from the cache, got %+v and %+v", first, second)
+	}
+	if stats := cache.Stats(); stats.Hits != missed || stats.Misses != missed {
+		t.Errorf("expected %d hits and %d misses, got %+v", missed, missed, stats)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+)
+
+// maxFlaky is the number of flaky jobs and tests shown in the report
+const maxFlaky = 20
+
+// Flakiness tells how often a job, or a test failing with a given normalized
+// error message, turned out to be flaky: out of the commits where it failed
+// (Failures), on how many of them the same job passed afterwards, without any
+// code change (Flaky). Score is the percentage of flaky failures.
+type Flakiness struct {
+	Repo     string
+	Workflow string
+	Name     string
+	Failures int
+	Flaky    int
+	Score    int
+}
+
+// commitJob identifies the runs of a job on a given commit
+type commitJob struct {
+	repo, workflow, job, sha string
+}
+
+// commitJobRuns groups the runs of each job by the commit they ran on,
+// ordered by start time. Runs without a commit, as found in data fetched by
+// older versions, are left out.
+func commitJobRuns(jobs []JobRun) map[commitJob][]JobRun {
+	runs := make(map[commitJob][]JobRun)
+	for _, job := range jobs {
+		if job.HeadSHA == "" {
+			continue
+		}
+		key := commitJob{job.Repo, job.Workflow, job.Job, job.HeadSHA}
+		runs[key] = append(runs[key], job)
+	}
+	for _, r := range runs {
+		sort.SliceStable(r, func(i, j int) bool { return r[i].Started.Before(r[j].Started.Time) })
+	}
+	return runs
+}
+
+// passedAfter tells whether any of the runs succeeded after failed started
+func passedAfter(runs []JobRun, failed JobRun) bool {
+	for _, run := range runs {
+		if run.Conclusion == "success" && run.Started.After(failed.Started.Time) {
+			return true
+		}
+	}
+	return false
+}
+
+// getFlakyJobs returns the jobs that failed and then passed on the same
+// commit, from more to less flaky
+func getFlakyJobs(jobs []JobRun) []Flakiness {
+	type jobKey struct{ repo, workflow, job string }
+	flakiness := make(map[jobKey]*Flakiness)
+	for key, runs := range commitJobRuns(jobs) {
+		for _, run := range runs {
+			if run.Conclusion != "failure" {
+				continue
+			}
+			k := jobKey{key.repo, key.workflow, key.job}
+			f, ok := flakiness[k]
+			if !ok {
+				f = &Flakiness{Repo: key.repo, Workflow: key.workflow, Name: key.job}
+				flakiness[k] = f
+			}
+			f.Failures++
+			if passedAfter(runs, run) {
+				f.Flaky++
+			}
+			// only the first failure on each commit counts
+			break
+		}
+	}
+
+	list := make([]*Flakiness, 0, len(flakiness))
+	for _, f := range flakiness {
+		list = append(list, f)
+	}
+	return rankFlakiness(list)
+}
+
+// getFlakyMessages returns the error messages of the tests that failed and
+// then passed on the same commit, from more to less flaky. Messages are
+// grouped once normalized by n, as in the clusters of the html report.
+func getFlakyMessages(jobs []JobRun, annotations []ErrorAnn, n normalize.Normalizer) []Flakiness {
+	runs := commitJobRuns(jobs)
+	type messageKey struct{ repo, workflow, message string }
+	type messageCommit struct {
+		messageKey
+		sha string
+	}
+	flakiness := make(map[messageKey]*Flakiness)
+	seen := make(map[messageCommit]bool)
+	for _, ann := range annotations {
+		if ann.HeadSHA == "" || ann.Conclusion != "failure" {
+			continue
+		}
+		k := messageKey{ann.Repo, ann.Workflow, n.Normalize(ann.Message)}
+		// only the first failure on each commit counts
+		if seen[messageCommit{k, ann.HeadSHA}] {
+			continue
+		}
+		seen[messageCommit{k, ann.HeadSHA}] = true
+
+		f, ok := flakiness[k]
+		if !ok {
+			f = &Flakiness{Repo: ann.Repo, Workflow: ann.Workflow, Name: k.message}
+			flakiness[k] = f
+		}
+		f.Failures++
+		if passedAfter(runs[commitJob{ann.Repo, ann.Workflow, ann.Job, ann.HeadSHA}], ann.JobRun) {
+			f.Flaky++
+		}
+	}
+
+	list := make([]*Flakiness, 0, len(flakiness))
+	for _, f := range flakiness {
+		list = append(list, f)
+	}
+	return rankFlakiness(list)
+}
+
+// rankFlakiness returns the entries that were flaky at least once, sorted by
+// number of flaky failures and then by score
+func rankFlakiness(list []*Flakiness) []Flakiness {
+	var flaky []Flakiness
+	for _, f := range list {
+		if f.Flaky == 0 {
+			continue
+		}
+		f.Score = f.Flaky * 100 / f.Failures
+		flaky = append(flaky, *f)
+	}
+	sort.Slice(flaky, func(i, j int) bool {
+		if flaky[i].Flaky != flaky[j].Flaky {
+			return flaky[i].Flaky > flaky[j].Flaky
+		}
+		if flaky[i].Score != flaky[j].Score {
+			return flaky[i].Score > flaky[j].Score
+		}
+		if flaky[i].Repo != flaky[j].Repo {
+			return flaky[i].Repo < flaky[j].Repo
+		}
+		if flaky[i].Workflow != flaky[j].Workflow {
+			return flaky[i].Workflow < flaky[j].Workflow
+		}
+		return flaky[i].Name < flaky[j].Name
+	})
+	return flaky
+}
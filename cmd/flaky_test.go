@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
+)
+
+func TestGetFlakyJobs(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	job := func(name, sha, conclusion string, minute int) JobRun {
+		return JobRun{
+			Repo:       "linkerd/linkerd2",
+			Workflow:   "CI",
+			Job:        name,
+			HeadSHA:    sha,
+			Conclusion: conclusion,
+			Started:    github.Timestamp{Time: start.Add(time.Duration(minute) * time.Minute)},
+		}
+	}
+	jobs := []JobRun{
+		// flaky on a, b and c, a real failure on d
+		job("integration", "a", "failure", 0),
+		job("integration", "a", "success", 10),
+		job("integration", "b", "failure", 0),
+		job("integration", "b", "failure", 10),
+		job("integration", "b", "success", 20),
+		job("integration", "c", "failure", 0),
+		job("integration", "c", "success", 10),
+		job("integration", "d", "failure", 0),
+		// passed before failing on e: not flaky
+		job("unit", "e", "success", 0),
+		job("unit", "e", "failure", 10),
+		// flaky once
+		job("lint", "f", "failure", 0),
+		job("lint", "f", "success", 10),
+		// no commit recorded
+		job("lint", "", "failure", 0),
+		job("lint", "", "success", 10),
+	}
+
+	flaky := getFlakyJobs(jobs)
+	if len(flaky) != 2 {
+		t.Fatalf("expected 2 flaky jobs, got %+v", flaky)
+	}
+	if f := flaky[0]; f.Name != "integration" || f.Flaky != 3 || f.Failures != 4 || f.Score != 75 {
+		t.Errorf("unexpected flakiness for the integration job: %+v", f)
+	}
+	if f := flaky[1]; f.Name != "lint" || f.Flaky != 1 || f.Failures != 1 || f.Score != 100 {
+		t.Errorf("unexpected flakiness for the lint job: %+v", f)
+	}
+
+	// the messages are grouped once normalized
+	annotations := []ErrorAnn{
+		{JobRun: jobs[0], Message: "TestInstall timed-out after 30s"},
+		{JobRun: jobs[2], Message: "TestInstall timed-out after 45s"},
+		{JobRun: jobs[3], Message: "TestInstall timed-out after 1m0s"},
+		{JobRun: jobs[7], Message: "TestInstall timed-out after 30s"},
+		{JobRun: jobs[7], Message: "TestUpgrade failed"},
+	}
+	messages := getFlakyMessages(jobs, annotations, normalize.Default())
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 flaky message, got %+v", messages)
+	}
+	if m := messages[0]; m.Name != "TestInstall timed-out after <duration>" || m.Flaky != 2 || m.Failures != 3 {
+		t.Errorf("unexpected flakiness for TestInstall: %+v", m)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
+)
+
+// maxHotspots is the number of files and line ranges shown in the report
+const maxHotspots = 20
+
+// runnerPath is the path of the annotations left by the Github Actions runner
+// itself, which don't point to any source file
+const runnerPath = ".github"
+
+// Hotspot holds the number of job runs that failed with annotations pointing
+// to a file, or to a line range of it when StartLine isn't zero, along with
+// the tests failing there. URL links to the location at the commit of the
+// latest failure, if known.
+type Hotspot struct {
+	Repo      string
+	Path      string
+	StartLine int
+	EndLine   int
+	Failures  int
+	Tests     pairlist.PairList
+	URL       string
+}
+
+// Location returns the path of the hotspot, followed by its line range if it
+// has one
+func (h Hotspot) Location() string {
+	switch {
+	case h.StartLine == 0:
+		return h.Path
+	case h.EndLine <= h.StartLine:
+		return fmt.Sprintf("%s:%d", h.Path, h.StartLine)
+	default:
+		return fmt.Sprintf("%s:%d-%d", h.Path, h.StartLine, h.EndLine)
+	}
+}
+
+// githubWebURL returns the base URL of the Github web UI serving the repos of
+// the API at apiURL. Github Enterprise serves its API under /api/v3 of the
+// web host.
+func githubWebURL(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if apiURL == "" || err != nil || u.Host == "api.github.com" {
+		return "https://github.com"
+	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/api/v3")
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+// blobURL returns the URL of the file at path in repo, at commit sha,
+// highlighting the given lines if startLine isn't zero
+func blobURL(webURL, repo, sha, path string, startLine, endLine int) string {
+	u := fmt.Sprintf("%s/%s/blob/%s/%s", webURL, repo, sha, path)
+	switch {
+	case startLine == 0:
+		return u
+	case endLine <= startLine:
+		return fmt.Sprintf("%s#L%d", u, startLine)
+	default:
+		return fmt.Sprintf("%s#L%d-L%d", u, startLine, endLine)
+	}
+}
+
+// getHotspots aggregates the failures by file and by line range, returning
+// both from more to less failures. Failures count the job runs with
+// annotations pointing to the location, however many there are in each one.
+// Annotations of the runner, which don't point to a file, are left out.
+func getHotspots(annotations []ErrorAnn, webURL string) (files, lines []Hotspot) {
+	type location struct {
+		repo, path         string
+		startLine, endLine int
+	}
+	type jobRun struct {
+		workflow, job string
+		checkRunID    int64
+		started       time.Time
+	}
+	type hotspot struct {
+		runs   map[jobRun]bool
+		tests  map[string]map[jobRun]bool
+		latest JobRun
+	}
+	hotspots := make(map[location]*hotspot)
+	add := func(loc location, ann ErrorAnn) {
+		h, ok := hotspots[loc]
+		if !ok {
+			h = &hotspot{runs: make(map[jobRun]bool), tests: make(map[string]map[jobRun]bool)}
+			hotspots[loc] = h
+		}
+		run := jobRun{ann.Workflow, ann.Job, ann.CheckRunID, ann.Started.Time}
+		h.runs[run] = true
+		if ann.Test != "" {
+			if h.tests[ann.Test] == nil {
+				h.tests[ann.Test] = make(map[jobRun]bool)
+			}
+			h.tests[ann.Test][run] = true
+		}
+		if ann.HeadSHA != "" && (h.latest.HeadSHA == "" || ann.Started.After(h.latest.Started.Time)) {
+			h.latest = ann.JobRun
+		}
+	}
+	for _, ann := range annotations {
+		if ann.Path == "" || ann.Path == runnerPath {
+			continue
+		}
+		add(location{ann.Repo, ann.Path, 0, 0}, ann)
+		if ann.StartLine > 0 {
+			add(location{ann.Repo, ann.Path, ann.StartLine, ann.EndLine}, ann)
+		}
+	}
+
+	for loc, h := range hotspots {
+		tests := make(map[string]int, len(h.tests))
+		for test, runs := range h.tests {
+			tests[test] = len(runs)
+		}
+		hotspot := Hotspot{
+			Repo:      loc.repo,
+			Path:      loc.path,
+			StartLine: loc.startLine,
+			EndLine:   loc.endLine,
+			Failures:  len(h.runs),
+			Tests:     rankByCount(tests),
+		}
+		if h.latest.HeadSHA != "" {
+			hotspot.URL = blobURL(webURL, loc.repo, h.latest.HeadSHA, loc.path, loc.startLine, loc.endLine)
+		}
+		if loc.startLine == 0 {
+			files = append(files, hotspot)
+		} else {
+			lines = append(lines, hotspot)
+		}
+	}
+	rank := func(list []Hotspot) {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Failures != list[j].Failures {
+				return list[i].Failures > list[j].Failures
+			}
+			if list[i].Repo != list[j].Repo {
+				return list[i].Repo < list[j].Repo
+			}
+			if list[i].Path != list[j].Path {
+				return list[i].Path < list[j].Path
+			}
+			if list[i].StartLine != list[j].StartLine {
+				return list[i].StartLine < list[j].StartLine
+			}
+			return list[i].EndLine < list[j].EndLine
+		})
+	}
+	rank(files)
+	rank(lines)
+	return files, lines
+}
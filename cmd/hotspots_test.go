@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
+)
+
+func TestGetHotspots(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	ann := func(checkRunID int64, sha, path string, line int, test string) ErrorAnn {
+		return ErrorAnn{
+			JobRun: JobRun{
+				Repo:       "linkerd/linkerd2",
+				Workflow:   "KinD integration",
+				Job:        "stable",
+				CheckRunID: checkRunID,
+				HeadSHA:    sha,
+				Started:    github.Timestamp{Time: start.Add(time.Duration(checkRunID) * time.Hour)},
+			},
+			Path:      path,
+			StartLine: line,
+			EndLine:   line,
+			Test:      test,
+		}
+	}
+	annotations := []ErrorAnn{
+		ann(1, "a", "test/install_test.go", 405, "TestInstall"),
+		// a second annotation at the same line in the same job run
+		ann(1, "a", "test/install_test.go", 405, "TestInstall"),
+		ann(2, "b", "test/install_test.go", 405, "TestUpgrade"),
+		ann(3, "c", "test/install_test.go", 405, "TestInstall"),
+		ann(3, "c", "test/install_test.go", 430, "TestInstall"),
+		ann(4, "", "test/tracing/tracing_test.go", 129, "TestTracing"),
+		ann(4, "", ".github", 1, ""),
+		ann(5, "d", "", 0, "TestEgress"),
+	}
+
+	files, lines := getHotspots(annotations, "https://github.com")
+	expectedFiles := []Hotspot{
+		{
+			Repo: "linkerd/linkerd2", Path: "test/install_test.go", Failures: 3,
+			Tests: pairlist.PairList{{Key: "TestInstall", Value: 2}, {Key: "TestUpgrade", Value: 1}},
+			URL:   "https://github.com/linkerd/linkerd2/blob/c/test/install_test.go",
+		},
+		{
+			Repo: "linkerd/linkerd2", Path: "test/tracing/tracing_test.go", Failures: 1,
+			Tests: pairlist.PairList{{Key: "TestTracing", Value: 1}},
+		},
+	}
+	if !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("expected files:\n%+v\ngot:\n%+v", expectedFiles, files)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 line ranges, got %+v", lines)
+	}
+	if l := lines[0]; l.Location() != "test/install_test.go:405" || l.Failures != 3 ||
+		l.URL != "https://github.com/linkerd/linkerd2/blob/c/test/install_test.go#L405" {
+		t.Errorf("unexpected hotspot %+v", l)
+	}
+	if l := lines[1]; l.Location() != "test/install_test.go:430" || l.Failures != 1 {
+		t.Errorf("unexpected hotspot %+v", l)
+	}
+}
+
+func TestGithubWebURL(t *testing.T) {
+	testCases := []struct {
+		apiURL   string
+		expected string
+	}{
+		{"", "https://github.com"},
+		{defaultAPIURL, "https://github.com"},
+		{"https://github.example.com/api/v3/", "https://github.example.com"},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080"},
+	}
+	for _, tc := range testCases {
+		if actual := githubWebURL(tc.apiURL); actual != tc.expected {
+			t.Errorf("web URL of %q: expected %q, got %q", tc.apiURL, tc.expected, actual)
+		}
+	}
+
+	if u := blobURL("https://github.com", "linkerd/linkerd2", "abc", "test/install_test.go", 10, 12); u != "https://github.com/linkerd/linkerd2/blob/abc/test/install_test.go#L10-L12" {
+		t.Errorf("unexpected blob URL %q", u)
+	}
+}
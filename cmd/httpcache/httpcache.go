@@ -0,0 +1,214 @@
+// Package httpcache implements an http.RoundTripper that caches GET responses
+// on disk and revalidates them through conditional requests (If-None-Match and
+// If-Modified-Since), so that unchanged resources come back as a 304 Not
+// Modified, which the Github API doesn't count against the rate limit.
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stats holds the number of requests answered from the cache (the server
+// replied with a 304) and the number of requests that weren't
+type Stats struct {
+	Hits   int
+	Misses int
+}
+
+// HitRatio returns the ratio of requests answered from the cache
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Transport is a caching http.RoundTripper. Entries are stored as files under
+// a directory, whose total size is kept under a limit by evicting the least
+// recently used entries.
+type Transport struct {
+	base    http.RoundTripper
+	dir     string
+	maxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	stats Stats
+}
+
+// New returns a Transport storing its entries under dir, up to maxSize bytes,
+// and sending requests through base (http.DefaultTransport if nil)
+func New(dir string, maxSize int64, base http.RoundTripper) (*Transport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	t := &Transport{base: base, dir: dir, maxSize: maxSize}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			t.size += f.Size()
+		}
+	}
+	return t, nil
+}
+
+// Stats returns the hit and miss counts since the Transport was created
+func (t *Transport) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// RoundTrip implements http.RoundTripper. The cache key includes the
+// Authorization header, so that responses aren't shared across credentials.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	path := t.entryPath(req)
+	cached := t.load(path, req)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		if etag := cached.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := cached.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		t.count(true)
+		// refresh the cached headers with the ones from the 304, which
+		// carry the current rate limit
+		for k, v := range resp.Header {
+			switch k {
+			case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+				continue
+			}
+			cached.Header[k] = v
+		}
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return cached, nil
+	}
+
+	t.count(false)
+	if resp.StatusCode != http.StatusOK ||
+		(resp.Header.Get("ETag") == "" && resp.Header.Get("Last-Modified") == "") {
+		return resp, nil
+	}
+
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	t.store(path, dump)
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+}
+
+func (t *Transport) count(hit bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if hit {
+		t.stats.Hits++
+	} else {
+		t.stats.Misses++
+	}
+}
+
+func (t *Transport) entryPath(req *http.Request) string {
+	key := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Authorization")))
+	return filepath.Join(t.dir, fmt.Sprintf("%x", key))
+}
+
+// load returns the cached response at path, or nil if there's none
+func (t *Transport) load(path string, req *http.Request) *http.Response {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// store writes the entry to path, evicting the least recently used entries
+// if the cache grows beyond its max size. Errors are ignored, as they only
+// mean the entry won't be cached.
+func (t *Transport) store(path string, data []byte) {
+	if int64(len(data)) > t.maxSize {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if info, err := os.Stat(path); err == nil {
+		t.size -= info.Size()
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	t.size += int64(len(data))
+
+	if t.size > t.maxSize {
+		t.evict(path)
+	}
+}
+
+// evict removes the least recently used entries, other than the one at keep,
+// until the cache size is under its max size
+func (t *Transport) evict(keep string) {
+	files, err := ioutil.ReadDir(t.dir)
+	if err != nil {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		if t.size <= t.maxSize {
+			break
+		}
+		if f.IsDir() || filepath.Join(t.dir, f.Name()) == keep {
+			continue
+		}
+		if err := os.Remove(filepath.Join(t.dir, f.Name())); err == nil {
+			t.size -= f.Size()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newServer returns a server answering with an ETag derived from the request
+// path, and with a 304 to conditional requests matching it
+func newServer(t *testing.T, requests *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		etag := fmt.Sprintf(`"%s"`, r.URL.Path)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(5000-*requests))
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fmt.Fprintf(w, "body of %s", r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, *http.Response) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	return string(body), resp
+}
+
+func TestTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int
+	server := newServer(t, &requests)
+	transport, err := New(dir, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	if body, _ := get(t, client, server.URL+"/a"); body != "body of /a" {
+		t.Errorf("unexpected body %q", body)
+	}
+	body, resp := get(t, client, server.URL+"/a")
+	if body != "body of /a" {
+		t.Errorf("unexpected cached body %q", body)
+	}
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "4998" {
+		t.Errorf("expected the headers to be refreshed from the 304, got remaining %s", remaining)
+	}
+
+	stats := transport.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRatio() != 0.5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if requests != 2 {
+		t.Errorf("expected 2 requests to reach the server, got %d", requests)
+	}
+}
+
+func TestTransportEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int
+	server := newServer(t, &requests)
+	// room for a single entry
+	transport, err := New(dir, 300, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	get(t, client, server.URL+"/a")
+	get(t, client, server.URL+"/b")
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected a single cache entry, found %d", len(files))
+	}
+
+	// /a was evicted, so it's a miss
+	get(t, client, server.URL+"/a")
+	if stats := transport.Stats(); stats.Hits != 0 || stats.Misses != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
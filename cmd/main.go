@@ -1,374 +1,358 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
 	"html/template"
-	"io/ioutil"
-	"log"
+	"io"
 	"os"
-	"strconv"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v31/github"
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/normalize"
 	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
 	"github.com/linkerd/linkerd2-ci-metrics/cmd/web"
-	"golang.org/x/oauth2"
 )
 
 const (
-	owner       = "linkerd"
-	repo        = "linkerd2"
-	tokenLabel  = "GITHUB_TOKEN"
-	refreshData = "REFRESH_DATA"
-
-	// throttling requests to the Github API for retrieving annotations
-	// at 1 req/sec, which puts us below the 5000 requests/hour limit
-	rateLimit = time.Second
+	tokenLabel    = "GITHUB_TOKEN"
+	defaultAPIURL = "https://api.github.com/"
 )
 
 var (
-	completed = "completed"
-	all       = "all"
-	workflows = map[string]workflowMeta{
-		"kind_integration.yml":  workflowMeta{"KinD integration", true},
-		"cloud_integration.yml": workflowMeta{"Cloud integration", true},
-		"release.yml":           workflowMeta{"Release", true},
-		"static_checks.yml":     workflowMeta{"Static checks", false},
-		"unit_tests.yml":        workflowMeta{"Unit tests", false},
-	}
-	ctx            context.Context
-	client         *github.Client
+	completed      = "completed"
+	all            = "all"
 	optBigListPage = github.ListOptions{PerPage: 100}
-	now            = time.Now()
-	monthAgo       = now.AddDate(0, -1, 0)
-	throttle       = time.Tick(rateLimit)
+	nonAlnum       = regexp.MustCompile("[^a-zA-Z0-9]+")
 )
 
-type workflowMeta struct {
-	name             string
-	fetchAnnotations bool
-}
-
 // JobRun holds the result state for a CI job, including the name of its
-// parent workflow
+// parent workflow and the repo (in the owner/repo form) it ran on. RunID,
+// RunAttempt and HeadSHA identify the workflow run the job belongs to, the
+// attempt of that run (starting at 1) and the commit it ran on.
 type JobRun struct {
+	Repo       string
 	Workflow   string
 	Job        string
+	CheckRunID int64
+	RunID      int64
+	RunAttempt int
+	HeadSHA    string
 	Conclusion string
 	Started    github.Timestamp
 	Completed  github.Timestamp
 }
 
 // ErrorAnn holds the details of a CI run failure extracted from a
-// Github annotation, and also points to its correspoinding JobRun
+// Github annotation, and also points to its correspoinding JobRun. Test is
+// the name of the Go test whose failure the message reports, with its
+// subtests, if any, and Detail is the rest of the message.
 type ErrorAnn struct {
 	JobRun
 	Path      string
 	StartLine int
 	EndLine   int
 	Message   string
+	Test      string `json:",omitempty"`
+	Detail    string `json:",omitempty"`
 }
 
 // WorkflowWithMessages hold the details of a particular Workflow run,
-// with its ID, Name, and list of error messages associated to it
+// with its ID, Name, Repo, and the clusters of error messages associated to it
 type WorkflowWithMessages struct {
 	Id       string
 	Name     string
-	Messages pairlist.PairList
+	Repo     string
+	Messages []normalize.Cluster
+}
+
+// RepoSuccessRates holds the success rates of a repo, globally and per
+// workflow and job
+type RepoSuccessRates struct {
+	Repo                 string
+	Runs                 int
+	SuccessRate          int
+	WorkflowSuccessRates pairlist.PairList
+	JobSuccessRates      pairlist.PairList
 }
 
 // Page holds the data passed to the HTML template
 type Page struct {
+	Title                string
 	ChartJS              template.JS
 	MainJS               template.JS
 	BootstrapCSS         template.CSS
 	MainCSS              template.CSS
 	JobSuccessRatesArr   template.JS
 	WorkflowsArr         template.JS
+	ReposArr             template.JS
+	JobDurationsArr      template.JS
+	Trends               template.JS
 	Start                string
 	End                  string
 	GlobalSuccessRate    int
 	WorkflowSuccessRates pairlist.PairList
+	RepoSuccessRates     []RepoSuccessRates
+	TestFailures         []TestFailures
+	HotspotFiles         []Hotspot
+	HotspotLines         []Hotspot
+	FlakyTests           []Flakiness
+	FlakyJobs            []Flakiness
+	WorkflowDurations    []DurationStats
+	Slowdowns            []Slowdown
+	ComparedToPrevious   bool
 }
 
-// getAnnotations returns the list of annotations for the checkRunID
-// Generic annotations with messages like "Process completed with exit code #"
-// are not considered, neither are the jobs that were canceled because a sibling
-// job didn't complete successfully.
-func getAnnotations(checkRunID int64, job JobRun) ([]ErrorAnn, error) {
-	annotations, _, err := client.Checks.ListCheckRunAnnotations(ctx, owner, repo, checkRunID, &optBigListPage)
-	if err != nil {
-		return nil, err
-	}
-
-	var errorAnns []ErrorAnn
-	for _, ann := range annotations {
-		if strings.Contains(ann.GetMessage(), "Process completed with exit code") ||
-			strings.Contains(ann.GetMessage(), "The job was canceled") {
+// getWorkflowMessages returns the clusters of error messages of each workflow
+// that has some, from larger to smaller. If qualify is true, workflow names
+// are prefixed with their repo.
+func getWorkflowMessages(workflows []WorkflowMetrics, qualify bool) []WorkflowWithMessages {
+	messages := []WorkflowWithMessages{}
+	for _, w := range workflows {
+		if len(w.Messages) == 0 {
 			continue
 		}
-		errorAnn := ErrorAnn{
-			JobRun:    job,
-			Path:      ann.GetPath(),
-			StartLine: ann.GetStartLine(),
-			EndLine:   ann.GetEndLine(),
-			Message:   ann.GetMessage(),
+		name := qualifiedName(w.Repo, w.Workflow, qualify)
+		clusters := make([]normalize.Cluster, len(w.Messages))
+		for i, c := range w.Messages {
+			clusters[i] = normalize.Cluster{Key: c.Message, Count: c.Count, Examples: c.Examples}
 		}
-		errorAnns = append(errorAnns, errorAnn)
+		messages = append(messages, WorkflowWithMessages{
+			Id:       nonAlnum.ReplaceAllString(name, "-"),
+			Name:     name,
+			Repo:     w.Repo,
+			Messages: clusters,
+		})
 	}
-	return errorAnns, nil
+	return messages
 }
 
-// getJobRuns returns the list of jobs and annotations for the given checkSuiteID and
-// workflow. Only the workflows that have been completed, haven't been cancelled
-// and started during the last month are returned. The third argument returns true if
-// there are more result pages available.
-func getJobRuns(checkSuiteID int64, workflow workflowMeta) ([]JobRun, []ErrorAnn, bool, error) {
-	opt := &github.ListCheckRunsOptions{Status: &completed, Filter: &all}
-	checkRuns, _, err := client.Checks.ListCheckRunsCheckSuite(ctx, owner, repo, int64(checkSuiteID), opt)
-	if err != nil {
-		return nil, nil, false, err
+// getRepos returns the sorted list of repos the runs belong to
+func getRepos(runs []JobRun) []string {
+	set := make(map[string]struct{})
+	for _, run := range runs {
+		set[run.Repo] = struct{}{}
 	}
-	// nextPage will be true if at least one job started this month.
-	// Invalid workflows will have no jobs ran; for them nextPage is
-	// true so that we still fetch the following page
-	nextPage := len(checkRuns.CheckRuns) == 0
-	var jobs []JobRun
-	var allAnns []ErrorAnn
-	for _, checkRun := range checkRuns.CheckRuns {
-		nextPage = nextPage || checkRun.GetStartedAt().After(monthAgo)
-		if checkRun.GetConclusion() == "cancelled" {
-			continue
-		}
+	repos := make([]string, 0, len(set))
+	for repo := range set {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	return repos
+}
 
-		job := JobRun{
-			Workflow:   workflow.name,
-			Job:        checkRun.GetName(),
-			Conclusion: checkRun.GetConclusion(),
-			Started:    checkRun.GetStartedAt(),
-			Completed:  checkRun.GetCompletedAt(),
-		}
+// reportTitle returns the title of the html report covering repos, in the
+// owner/repo form
+func reportTitle(repos []string) string {
+	if len(repos) == 0 {
+		return "CI Metrics"
+	}
+	return strings.Join(repos, ", ") + " CI Metrics"
+}
 
-		jobs = append(jobs, job)
+// qualifiedName prefixes name with repo, for disambiguating workflows and
+// jobs when aggregating over more than one repo
+func qualifiedName(repo, name string, qualify bool) string {
+	if !qualify {
+		return name
+	}
+	return repo + ": " + name
+}
 
-		if !workflow.fetchAnnotations {
-			continue
+// rankByCount returns the counts from larger to smaller, ties being sorted by
+// key
+func rankByCount(counts map[string]int) pairlist.PairList {
+	ranked := pairlist.RankByValue(counts, true)
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Value != ranked[j].Value {
+			return ranked[i].Value > ranked[j].Value
 		}
+		return ranked[i].Key < ranked[j].Key
+	})
+	return ranked
+}
 
-		<-throttle
-		anns, err := getAnnotations(checkRun.GetID(), job)
-		if err != nil {
-			return nil, nil, false, err
-		}
-		allAnns = append(allAnns, anns...)
+// percent returns the success rate of t in percent, rounded down
+func (t RunTotals) percent() int {
+	if t.Runs == 0 {
+		return 0
 	}
+	return t.Successes * 100 / t.Runs
+}
 
-	if !nextPage {
-		return nil, nil, false, nil
+// getJobSuccessRates returns the success rates of the jobs, whatever their
+// workflow, ordered from least to most sucessful. If qualify is true, job
+// names are prefixed with their repo.
+func getJobSuccessRates(jobs []JobMetrics, qualify bool) pairlist.PairList {
+	totals := make(map[string]RunTotals)
+	for _, j := range jobs {
+		name := qualifiedName(j.Repo, j.Job, qualify)
+		t := totals[name]
+		t.Runs += j.Runs
+		t.Successes += j.Successes
+		totals[name] = t
 	}
-
-	return jobs, allAnns, true, nil
+	rates := make(map[string]int, len(totals))
+	for name, t := range totals {
+		rates[name] = t.percent()
+	}
+	return pairlist.RankByValue(rates, false)
 }
 
-// getData builds the list of jobs and annotations for the current repo,
-// calling the Github API
-func getData() ([]JobRun, []ErrorAnn, error) {
-	token, ok := os.LookupEnv(tokenLabel)
-	if !ok {
-		return nil, nil, fmt.Errorf("%s env var required", tokenLabel)
+// getWorkflowSuccessRates returns the success rate of each workflow that ran,
+// ordered from less to more successful. If qualify is true, workflow names
+// are prefixed with their repo.
+func getWorkflowSuccessRates(workflows []WorkflowMetrics, qualify bool) pairlist.PairList {
+	rates := make(map[string]int)
+	for _, w := range workflows {
+		if w.Runs > 0 {
+			rates[qualifiedName(w.Repo, w.Workflow, qualify)] = w.percent()
+		}
 	}
+	return pairlist.RankByValue(rates, false)
+}
 
-	ctx = context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-
-	client = github.NewClient(tc)
-	var jobs []JobRun
-	var annotations []ErrorAnn
-	for workflowFile, workflowMeta := range workflows {
-		opt := &github.ListWorkflowRunsOptions{"", "", "", "", optBigListPage}
-	out:
-		for {
-			runs, resp, err := client.Actions.ListWorkflowRunsByFileName(ctx, owner, repo, workflowFile, opt)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			var workflowJobs []JobRun
-			var workflowAnnotations []ErrorAnn
-			for _, run := range runs.WorkflowRuns {
-				if run.GetConclusion() == "cancelled" {
-					continue
-				}
-				url := run.GetCheckSuiteURL()
-				checkSuiteIDstr := url[strings.LastIndex(url, "/")+1:]
-				checkSuiteID, err := strconv.Atoi(checkSuiteIDstr)
-				if err != nil {
-					continue
-				}
-
-				jobRuns, jobAnnotations, nextPage, err := getJobRuns(int64(checkSuiteID), workflowMeta)
-				if err != nil {
-					return nil, nil, err
-				}
-				if !nextPage {
-					jobs = append(jobs, workflowJobs...)
-					annotations = append(annotations, workflowAnnotations...)
-					break out
-				}
-				workflowJobs = append(workflowJobs, jobRuns...)
-				workflowAnnotations = append(workflowAnnotations, jobAnnotations...)
+// getRepoSuccessRates returns the success rates for each one of the repos
+func getRepoSuccessRates(m *Metrics) []RepoSuccessRates {
+	rates := make([]RepoSuccessRates, len(m.Repos))
+	for i, repo := range m.Repos {
+		var workflows []WorkflowMetrics
+		for _, w := range m.Workflows {
+			if w.Repo == repo.Repo {
+				workflows = append(workflows, w)
 			}
-
-			jobs = append(jobs, workflowJobs...)
-			annotations = append(annotations, workflowAnnotations...)
-			if resp.NextPage == 0 {
-				break
+		}
+		var jobs []JobMetrics
+		for _, j := range m.Jobs {
+			if j.Repo == repo.Repo {
+				jobs = append(jobs, j)
 			}
-			opt.Page = resp.NextPage
 		}
-	}
-
-	return jobs, annotations, nil
-}
-
-func getWorkflowMessages(workflow string, annotations []ErrorAnn) pairlist.PairList {
-	messages := map[string]int{}
-	for _, ann := range annotations {
-		if ann.Workflow != workflow {
-			continue
+		rates[i] = RepoSuccessRates{
+			Repo:                 repo.Repo,
+			Runs:                 repo.Runs,
+			SuccessRate:          repo.percent(),
+			WorkflowSuccessRates: getWorkflowSuccessRates(workflows, false),
+			JobSuccessRates:      getJobSuccessRates(jobs, false),
 		}
-		messages[ann.Message]++
 	}
+	return rates
+}
 
-	return pairlist.RankByValue(messages, true)
+// reportOptions tweaks how a report is built
+type reportOptions struct {
+	// previous is the snapshot of the previous window, if any, which job
+	// durations are compared against
+	previous *snapshot
+	// slowdownThreshold is the growth of a job's median duration versus the
+	// previous window, in percent, above which it's reported as slower
+	slowdownThreshold int
+	// bucket is the size of the buckets of the success rate trends, day or
+	// week, chosen according to the window if empty
+	bucket string
+	// clusterer groups the error messages of each workflow
+	clusterer normalize.Clusterer
+	// top is the number of jobs and error messages ranked in the Markdown
+	// summary, defaultTop if zero
+	top int
 }
 
-// getJobSuccessRates returns a json array for the job success rates
-// ordered from least to most sucessful
-func getJobSuccessRates(runs []JobRun) ([]byte, error) {
-	totalRuns := make(map[string]int)
-	successes := make(map[string]int)
-	for _, run := range runs {
-		totalRuns[run.Job]++
-		if run.Conclusion == "success" {
-			successes[run.Job]++
-		}
+// messageClusterer returns the clusterer grouping the error messages, which
+// is the default one if none was set
+func (o reportOptions) messageClusterer() normalize.Clusterer {
+	if o.clusterer.Normalizer == nil {
+		return defaultConfig().clusterer()
 	}
-	for job, num := range successes {
-		successes[job] = num * 100 / totalRuns[job]
-	}
-	json, err := json.Marshal(pairlist.RankByValue(successes, false))
-	if err != nil {
-		return nil, err
-	}
-	return json, nil
+	return o.clusterer
 }
 
-// getWorkflowSuccessRates returns the global success rate and the success rate
-// for each workflow (ordered from less to more successful)
-func getWorkflowSuccessRates(runs []JobRun) (int, pairlist.PairList) {
-	totalRuns := 0
-	totalSuccesses := 0
-	totalRunsPerJob := make(map[string]int)
-	successes := make(map[string]int)
-	for _, run := range runs {
-		totalRuns++
-		totalRunsPerJob[run.Workflow]++
-		if run.Conclusion == "success" {
-			totalSuccesses++
-			successes[run.Workflow]++
-		}
-	}
-	for workflow, num := range successes {
-		successes[workflow] = num * 100 / totalRunsPerJob[workflow]
-	}
+// processData renders the success rates, error messages, durations, trends,
+// flaky tests and failure hotspots of the report r to out as an html page
+func processData(out io.Writer, r *report) error {
+	multiRepo := r.multiRepo()
 
-	if totalRuns > 0 {
-		return totalSuccesses * 100 / totalRuns, pairlist.RankByValue(successes, false)
+	jobSuccessRatesJSON, err := json.Marshal(getJobSuccessRates(r.metrics.Jobs, multiRepo))
+	if err != nil {
+		return err
 	}
-	return 0, pairlist.PairList{}
-}
-
-// processData retrieves all the CI success and error message metrics and
-// displays them in an index.html file
-func processData(jobs []JobRun, annotations []ErrorAnn) error {
-	jobSuccessRatesJSON, err := getJobSuccessRates(jobs)
+	workflowsJSON, err := json.Marshal(getWorkflowMessages(r.metrics.Workflows, multiRepo))
 	if err != nil {
 		return err
 	}
-
-	setWorkflows := make(map[string]struct{})
-	for _, ann := range annotations {
-		setWorkflows[ann.Workflow] = struct{}{}
+	repoSuccessRates := getRepoSuccessRates(r.metrics)
+	reposJSON, err := json.Marshal(repoSuccessRates)
+	if err != nil {
+		return err
 	}
-
-	messages := []WorkflowWithMessages{}
-	for workflow := range setWorkflows {
-		m := WorkflowWithMessages{
-			Id:       strings.ReplaceAll(workflow, " ", "-"),
-			Name:     workflow,
-			Messages: getWorkflowMessages(workflow, annotations),
-		}
-		messages = append(messages, m)
+	jobDurationsJSON, err := json.Marshal(r.jobDurationStats)
+	if err != nil {
+		return err
 	}
-	workflowsJSON, err := json.Marshal(messages)
+	trendsJSON, err := json.Marshal(r.trends)
 	if err != nil {
 		return err
 	}
 
-	globalSuccessRate, workflowSuccessRates := getWorkflowSuccessRates(jobs)
+	testFailures := r.testFailures
+	if len(testFailures) > maxTestFailures {
+		testFailures = testFailures[:maxTestFailures]
+	}
+	hotspotFiles, hotspotLines := r.hotspotFiles, r.hotspotLines
+	if len(hotspotFiles) > maxHotspots {
+		hotspotFiles = hotspotFiles[:maxHotspots]
+	}
+	if len(hotspotLines) > maxHotspots {
+		hotspotLines = hotspotLines[:maxHotspots]
+	}
+	flakyTests, flakyJobs := r.flakyTests, r.flakyJobs
+	if len(flakyTests) > maxFlaky {
+		flakyTests = flakyTests[:maxFlaky]
+	}
+	if len(flakyJobs) > maxFlaky {
+		flakyJobs = flakyJobs[:maxFlaky]
+	}
 
 	tpl, err := template.New("index").Parse(web.Index)
 	if err != nil {
 		return err
 	}
+	repos := r.snapshot.Repos
+	if len(repos) == 0 {
+		repos = getRepos(r.snapshot.Jobs)
+	}
 	data := Page{
+		Title:                reportTitle(repos),
 		ChartJS:              template.JS(web.ChartJS),
 		MainJS:               template.JS(web.MainJS),
 		BootstrapCSS:         template.CSS(web.BootstrapCSS),
 		MainCSS:              template.CSS(web.MainCSS),
 		JobSuccessRatesArr:   template.JS(jobSuccessRatesJSON),
 		WorkflowsArr:         template.JS(workflowsJSON),
-		Start:                monthAgo.Format(time.RFC822),
-		End:                  now.Format(time.RFC822),
-		GlobalSuccessRate:    globalSuccessRate,
-		WorkflowSuccessRates: workflowSuccessRates,
+		ReposArr:             template.JS(reposJSON),
+		JobDurationsArr:      template.JS(jobDurationsJSON),
+		Trends:               template.JS(trendsJSON),
+		Start:                r.snapshot.Window.Since.Format(time.RFC822),
+		End:                  r.snapshot.Window.Until.Format(time.RFC822),
+		GlobalSuccessRate:    r.metrics.Totals.percent(),
+		WorkflowSuccessRates: getWorkflowSuccessRates(r.metrics.Workflows, multiRepo),
+		RepoSuccessRates:     repoSuccessRates,
+		TestFailures:         testFailures,
+		HotspotFiles:         hotspotFiles,
+		HotspotLines:         hotspotLines,
+		FlakyTests:           flakyTests,
+		FlakyJobs:            flakyJobs,
+		WorkflowDurations:    r.workflowDurationStats,
+		Slowdowns:            r.slowdowns,
+		ComparedToPrevious:   r.previous != nil,
 	}
-	if err := tpl.Execute(os.Stdout, data); err != nil {
+	if err := tpl.Execute(out, data); err != nil {
 		return err
 	}
 	return nil
 }
 
 func main() {
-	jobs, annotations, err := getData()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if _, ok := os.LookupEnv(refreshData); ok {
-		b, err := json.MarshalIndent(jobs, "", "  ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if err = ioutil.WriteFile("cmd/testdata/jobs.json", b, 0664); err != nil {
-			log.Fatal(err)
-		}
-
-		b, err = json.MarshalIndent(annotations, "", "  ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		if err = ioutil.WriteFile("cmd/testdata/annotations.json", b, 0664); err != nil {
-			log.Fatal(err)
-		}
-	}
-	if err = processData(jobs, annotations); err != nil {
-		log.Fatal(err)
-	}
+	os.Exit(run(os.Args[1:]))
 }
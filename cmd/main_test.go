@@ -1,23 +1,67 @@
 package main
 
 import (
-	"encoding/json"
 	"io/ioutil"
+	"os"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
 )
 
 func TestProcessData(t *testing.T) {
-	var jobs []JobRun
-	var annotations []ErrorAnn
-	data1, _ := ioutil.ReadFile("testdata/jobs.json")
-	if err := json.Unmarshal(data1, &jobs); err != nil {
+	// testdata holds jobs.json and annotations.json, in the legacy format
+	s, err := readSnapshot("testdata")
+	if err != nil {
 		t.Fatal(err)
 	}
-	data2, _ := ioutil.ReadFile("testdata/annotations.json")
-	if err := json.Unmarshal(data2, &annotations); err != nil {
+	if err := processData(os.Stdout, newReport(s, reportOptions{})); err != nil {
 		t.Fatal(err)
 	}
-	if err := processData(jobs, annotations); err != nil {
+}
+
+func TestRepoSuccessRates(t *testing.T) {
+	jobs := []JobRun{
+		{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "test", Conclusion: "success"},
+		{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "test", Conclusion: "failure"},
+		{Repo: "linkerd/linkerd2-proxy", Workflow: "CI", Job: "test", Conclusion: "success"},
+		{Repo: "linkerd/linkerd2-proxy", Workflow: "CI", Job: "test", Conclusion: "success"},
+	}
+
+	m := getMetrics(&snapshot{Jobs: jobs}, reportOptions{})
+	rates := getRepoSuccessRates(m)
+	if len(rates) != 2 {
+		t.Fatalf("expected 2 repos, got %d", len(rates))
+	}
+	if rates[0].Repo != "linkerd/linkerd2" || rates[0].SuccessRate != 50 || rates[0].Runs != 2 {
+		t.Errorf("unexpected rates for linkerd2: %+v", rates[0])
+	}
+	if rates[1].Repo != "linkerd/linkerd2-proxy" || rates[1].SuccessRate != 100 {
+		t.Errorf("unexpected rates for linkerd2-proxy: %+v", rates[1])
+	}
+
+	global, workflows := m.Totals.percent(), getWorkflowSuccessRates(m.Workflows, true)
+	if global != 75 {
+		t.Errorf("expected global success rate of 75, got %d", global)
+	}
+	if len(workflows) != 2 || workflows[0].Key != "linkerd/linkerd2: CI" || workflows[0].Value != 50 {
+		t.Errorf("unexpected workflow success rates: %+v", workflows)
+	}
+
+	// the jobs that never succeeded are ranked first, and the runs of a job
+	// are counted whatever their workflow
+	jobs = append(jobs,
+		JobRun{Repo: "linkerd/linkerd2", Workflow: "Release", Job: "test", Conclusion: "success"},
+		JobRun{Repo: "linkerd/linkerd2", Workflow: "Release", Job: "release", Conclusion: "failure"},
+	)
+	m = getMetrics(&snapshot{Jobs: jobs}, reportOptions{})
+	expected := pairlist.PairList{{Key: "release", Value: 0}, {Key: "test", Value: 66}}
+	if rates := getJobSuccessRates(m.Jobs[:3], false); !reflect.DeepEqual(rates, expected) {
+		t.Errorf("expected job success rates %+v, got %+v", expected, rates)
+	}
+
+	if err := processData(ioutil.Discard, newReport(&snapshot{Window: defaultWindow(time.Now()), Jobs: jobs}, reportOptions{})); err != nil {
 		t.Fatal(err)
 	}
 }
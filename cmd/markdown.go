@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/web"
+)
+
+const (
+	// defaultTop is the default number of jobs and error messages ranked in
+	// the Markdown summary
+	defaultTop = 10
+	// week is the period whose success rates are compared against those of
+	// the one before in the Markdown summary, without a previous window
+	week = 7 * 24 * time.Hour
+)
+
+// Summary holds the data passed to the Markdown summary template. Delta is the
+// change of the global success rate, in points, if Compared, and Versus tells
+// what it's compared against.
+type Summary struct {
+	Start       string
+	End         string
+	Totals      RunTotals
+	Delta       string
+	Compared    bool
+	Versus      string
+	Workflows   []SummaryRow
+	FailingJobs []SummaryRow
+	Jobs        []SummaryRow
+	Messages    []SummaryMessage
+}
+
+// SummaryRow holds the totals of a workflow, or of a job when Job is set.
+// Name is the name of the workflow, prefixed by its repo if the summary covers
+// more than one, and Delta the change of the success rate, in points.
+type SummaryRow struct {
+	Name string
+	Job  string
+	RunTotals
+	Delta string
+}
+
+// SummaryMessage is a group of equivalent error messages of a workflow
+type SummaryMessage struct {
+	Name string
+	MessageCount
+}
+
+// markdownEscaper escapes the characters of table cells that Markdown would
+// otherwise interpret, replacing line breaks, which would end the row
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "~", `\~`, "[", `\[`, "]", `\]`,
+	"|", `\|`, "<", "&lt;", ">", "&gt;", "\r\n", " ", "\n", " ",
+)
+
+var summaryFuncs = template.FuncMap{
+	"md": markdownEscaper.Replace,
+	"pct": func(rate float64) string {
+		return fmt.Sprintf("%.1f%%", 100*rate)
+	},
+	// code returns s as a fenced code block, whose fence is longer than any
+	// run of backticks in s
+	"code": func(s string) string {
+		fence := "```"
+		for strings.Contains(s, fence) {
+			fence += "`"
+		}
+		return fence + "\n" + s + "\n" + fence
+	},
+}
+
+// periodTotals holds the totals of the job runs of a period, globally and by
+// workflow and job, whose success rates are compared in the Markdown summary
+type periodTotals struct {
+	totals    RunTotals
+	workflows map[[2]string]RunTotals
+	jobs      map[[3]string]RunTotals
+}
+
+// metricsTotals returns the totals of the metrics m
+func metricsTotals(m *Metrics) *periodTotals {
+	t := &periodTotals{
+		totals:    m.Totals,
+		workflows: make(map[[2]string]RunTotals, len(m.Workflows)),
+		jobs:      make(map[[3]string]RunTotals, len(m.Jobs)),
+	}
+	for _, w := range m.Workflows {
+		t.workflows[[2]string{w.Repo, w.Workflow}] = w.RunTotals
+	}
+	for _, j := range m.Jobs {
+		t.jobs[[3]string{j.Repo, j.Workflow, j.Job}] = j.RunTotals
+	}
+	return t
+}
+
+// getPeriodTotals returns the totals of the jobs whose start time is within
+// the period
+func getPeriodTotals(jobs []JobRun, within func(time.Time) bool) *periodTotals {
+	var global successCounts
+	workflows := make(map[[2]string]*successCounts)
+	jobCounts := make(map[[3]string]*successCounts)
+	for _, job := range jobs {
+		if !within(job.Started.Time) {
+			continue
+		}
+		global.add(job)
+		wKey := [2]string{job.Repo, job.Workflow}
+		if workflows[wKey] == nil {
+			workflows[wKey] = &successCounts{}
+		}
+		workflows[wKey].add(job)
+		jKey := [3]string{job.Repo, job.Workflow, job.Job}
+		if jobCounts[jKey] == nil {
+			jobCounts[jKey] = &successCounts{}
+		}
+		jobCounts[jKey].add(job)
+	}
+
+	t := &periodTotals{
+		totals:    global.totals(),
+		workflows: make(map[[2]string]RunTotals, len(workflows)),
+		jobs:      make(map[[3]string]RunTotals, len(jobCounts)),
+	}
+	for key, counts := range workflows {
+		t.workflows[key] = counts.totals()
+	}
+	for key, counts := range jobCounts {
+		t.jobs[key] = counts.totals()
+	}
+	return t
+}
+
+// getSummaryPeriods returns the totals whose success rates are compared in the
+// Markdown summary of the snapshot s: those of the previous window and of s if
+// previous is set, or else those of the week before the last one of the window
+// and of the last one. Nothing is compared, and nil is returned, if the window
+// is shorter than two weeks.
+func getSummaryPeriods(s *snapshot, metrics, previous *Metrics) (before, after *periodTotals) {
+	if previous != nil {
+		return metricsTotals(previous), metricsTotals(metrics)
+	}
+	if s.Window.Until.Sub(s.Window.Since) < 2*week {
+		return nil, nil
+	}
+	lastWeek := s.Window.Until.Add(-week)
+	before = getPeriodTotals(s.Jobs, func(t time.Time) bool {
+		return !t.Before(lastWeek.Add(-week)) && t.Before(lastWeek)
+	})
+	after = getPeriodTotals(s.Jobs, func(t time.Time) bool {
+		return !t.Before(lastWeek)
+	})
+	return before, after
+}
+
+// rateDelta returns the change from the previous success rate to the current
+// one, in points, "new" if there was no previous run, or "-" if there's no
+// current one
+func rateDelta(current RunTotals, previous RunTotals, found bool) string {
+	if current.Runs == 0 {
+		return "-"
+	}
+	if !found || previous.Runs == 0 {
+		return "new"
+	}
+	return fmt.Sprintf("%+.1f", 100*(current.SuccessRate-previous.SuccessRate))
+}
+
+// writeMarkdown writes a summary of the report r to out as Markdown, to be
+// posted in an issue or as the summary of a Github Actions job: the global
+// success rate, the success rate of each workflow, the opts.top jobs that
+// failed the most and the opts.top most frequent error messages. The success
+// rates are compared against those of the previous window if set, or else
+// week over week, as given by r.summaryBefore and r.summaryAfter.
+func writeMarkdown(out io.Writer, r *report) error {
+	s, m := r.snapshot, r.metrics
+	before, after := r.summaryBefore, r.summaryAfter
+	top := r.opts.top
+	if top <= 0 {
+		top = defaultTop
+	}
+	multiRepo := r.multiRepo()
+
+	summary := Summary{
+		Start:    s.Window.Since.UTC().Format("2006-01-02 15:04 MST"),
+		End:      s.Window.Until.UTC().Format("2006-01-02 15:04 MST"),
+		Totals:   m.Totals,
+		Compared: before != nil,
+		Versus:   "week over week",
+	}
+	if r.previous != nil {
+		summary.Versus = "versus the previous window"
+	}
+	if before != nil && before.totals.Runs > 0 && after.totals.Runs > 0 {
+		summary.Delta = rateDelta(after.totals, before.totals, true)
+	}
+
+	for _, w := range m.Workflows {
+		if w.Runs == 0 {
+			continue
+		}
+		row := SummaryRow{Name: qualifiedName(w.Repo, w.Workflow, multiRepo), RunTotals: w.RunTotals}
+		if before != nil {
+			key := [2]string{w.Repo, w.Workflow}
+			p, ok := before.workflows[key]
+			row.Delta = rateDelta(after.workflows[key], p, ok)
+		}
+		summary.Workflows = append(summary.Workflows, row)
+		for _, c := range w.Messages {
+			summary.Messages = append(summary.Messages, SummaryMessage{Name: row.Name, MessageCount: c})
+		}
+	}
+	for _, j := range m.Jobs {
+		row := SummaryRow{Name: qualifiedName(j.Repo, j.Workflow, multiRepo), Job: j.Job, RunTotals: j.RunTotals}
+		if before != nil {
+			key := [3]string{j.Repo, j.Workflow, j.Job}
+			p, ok := before.jobs[key]
+			row.Delta = rateDelta(after.jobs[key], p, ok)
+		}
+		summary.Jobs = append(summary.Jobs, row)
+		if row.Failures > 0 {
+			summary.FailingJobs = append(summary.FailingJobs, row)
+		}
+	}
+
+	sort.SliceStable(summary.FailingJobs, func(i, j int) bool {
+		a, b := summary.FailingJobs[i], summary.FailingJobs[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		return a.SuccessRate < b.SuccessRate
+	})
+	if len(summary.FailingJobs) > top {
+		summary.FailingJobs = summary.FailingJobs[:top]
+	}
+	sort.SliceStable(summary.Messages, func(i, j int) bool {
+		return summary.Messages[i].Count > summary.Messages[j].Count
+	})
+	if len(summary.Messages) > top {
+		summary.Messages = summary.Messages[:top]
+	}
+
+	tpl, err := template.New("summary").Funcs(summaryFuncs).Parse(web.Summary)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(out, summary)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	job := func(workflow, name, conclusion string) JobRun {
+		return JobRun{Repo: "linkerd/linkerd2", Workflow: workflow, Job: name, Conclusion: conclusion}
+	}
+	jobs := []JobRun{
+		job("CI", "unit", "success"),
+		job("CI", "unit", "failure"),
+		job("CI", "lint | vet", "failure"),
+		job("CI", "lint | vet", "failure"),
+		job("CI", "integration", "failure"),
+		job("CI", "integration", "success"),
+		job("CI", "integration", "success"),
+		job("CI", "integration", "success"),
+		job("Docs", "build", "success"),
+	}
+	s := &snapshot{
+		Jobs: jobs,
+		Annotations: []ErrorAnn{
+			{JobRun: jobs[1], Message: "TestUnit failed after 3s"},
+			{JobRun: jobs[4], Message: "TestUnit failed after 5s"},
+			{JobRun: jobs[2], Message: "expected <nil>, got ```err```"},
+		},
+	}
+	previous := &snapshot{Jobs: []JobRun{
+		job("CI", "unit", "success"),
+		job("CI", "unit", "success"),
+		job("CI", "integration", "success"),
+		job("CI", "integration", "failure"),
+	}}
+
+	var out bytes.Buffer
+	if err := writeMarkdown(&out, newReport(s, reportOptions{previous: previous, top: 2})); err != nil {
+		t.Fatal(err)
+	}
+	md := out.String()
+	expected := []string{
+		"**55.6%** of the 9 job runs succeeded (-19.4 points versus the previous window).",
+		"| CI | 8 | 4 | 50.0% | -25.0 |",
+		"| Docs | 1 | 0 | 100.0% | new |",
+		// the jobs that failed the most, up to top
+		"### Top failing jobs\n\n| Job | Workflow | Failures | Runs | Success rate | Change |\n" +
+			"|-----|----------|---------:|-----:|-------------:|-------:|\n" +
+			`| lint \| vet | CI | 2 | 2 | 0.0% | new |` + "\n" +
+			"| unit | CI | 1 | 2 | 50.0% | -50.0 |\n\n",
+		"<summary>All 4 jobs</summary>",
+		"| integration | CI | 1 | 4 | 75.0% | +25.0 |",
+		"<summary>2 × TestUnit failed after &lt;duration&gt; (CI)</summary>",
+		"```\nTestUnit failed after 3s\n```",
+		"<summary>1 × expected &lt;nil&gt;, got ```err``` (CI)</summary>",
+		"````\nexpected <nil>, got ```err```\n````",
+	}
+	for _, e := range expected {
+		if !strings.Contains(md, e) {
+			t.Errorf("expected %q in:\n%s", e, md)
+		}
+	}
+	if strings.Count(md, "<details>") != 3 {
+		t.Errorf("expected 3 details sections, got:\n%s", md)
+	}
+
+	// without a previous window, and with a window shorter than two weeks,
+	// there's no change to report
+	out.Reset()
+	if err := writeMarkdown(&out, newReport(&snapshot{}, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	md = out.String()
+	for _, e := range []string{"No job ran.", "No job failed.", "No error message."} {
+		if !strings.Contains(md, e) {
+			t.Errorf("expected %q in:\n%s", e, md)
+		}
+	}
+	if strings.Contains(md, "Change") || strings.Contains(md, "previous window") {
+		t.Errorf("expected no comparison in:\n%s", md)
+	}
+}
+
+func TestWriteMarkdownWeekOverWeek(t *testing.T) {
+	until := time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC)
+	job := func(name, conclusion string, daysAgo int) JobRun {
+		started := until.Add(-time.Duration(daysAgo) * 24 * time.Hour)
+		return JobRun{
+			Repo:       "linkerd/linkerd2",
+			Workflow:   "CI",
+			Job:        name,
+			Conclusion: conclusion,
+			Started:    github.Timestamp{Time: started},
+		}
+	}
+	s := &snapshot{
+		Window: window{Since: until.AddDate(0, -1, 0), Until: until},
+		Jobs: []JobRun{
+			// the last week
+			job("unit", "success", 1),
+			job("unit", "success", 2),
+			job("integration", "success", 3),
+			job("integration", "failure", 7),
+			// the week before
+			job("unit", "failure", 8),
+			job("unit", "success", 9),
+			job("integration", "success", 10),
+			job("lint", "failure", 11),
+			// earlier on, not compared
+			job("unit", "failure", 20),
+			job("integration", "failure", 20),
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeMarkdown(&out, newReport(s, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	md := out.String()
+	expected := []string{
+		"**50.0%** of the 10 job runs succeeded (+25.0 points week over week).",
+		"| CI | 10 | 5 | 50.0% | +25.0 |",
+		"| integration | CI | 2 | 4 | 50.0% | -50.0 |",
+		"| lint | CI | 1 | 1 | 0.0% | - |",
+		"| unit | CI | 2 | 5 | 60.0% | +50.0 |",
+	}
+	for _, e := range expected {
+		if !strings.Contains(md, e) {
+			t.Errorf("expected %q in:\n%s", e, md)
+		}
+	}
+}
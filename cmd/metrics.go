@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// metricsVersion is the version of the schema of the JSON metrics. It must be
+// bumped whenever a field is removed or changes meaning; new fields can be
+// added without bumping it.
+const metricsVersion = 1
+
+// Metrics holds the metrics computed from a snapshot, as rendered by the json
+// format for other tools to consume. Workflows and jobs are sorted by repo,
+// workflow and job name.
+type Metrics struct {
+	Version int `json:"version"`
+	// FetchedAt is when the data was fetched from Github, if known
+	FetchedAt *time.Time        `json:"fetchedAt,omitempty"`
+	Window    MetricsWindow     `json:"window"`
+	Totals    RunTotals         `json:"totals"`
+	Repos     []RepoMetrics     `json:"repos"`
+	Workflows []WorkflowMetrics `json:"workflows"`
+	Jobs      []JobMetrics      `json:"jobs"`
+}
+
+// MetricsWindow is the reporting window, the jobs that started within it
+// being the ones counted
+type MetricsWindow struct {
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+}
+
+// RunTotals counts the job runs of a group of jobs. Failures are the runs that
+// didn't succeed, whatever their conclusion, and SuccessRate is the share of
+// runs that succeeded, between 0 and 1 (0 when there are no runs).
+type RunTotals struct {
+	Runs        int     `json:"runs"`
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"successRate"`
+}
+
+// RepoMetrics holds the totals of the job runs of a repo
+type RepoMetrics struct {
+	Repo string `json:"repo"`
+	RunTotals
+}
+
+// WorkflowMetrics holds the totals of the job runs of a workflow, and its
+// error messages ranked from more to less frequent
+type WorkflowMetrics struct {
+	Repo     string `json:"repo"`
+	Workflow string `json:"workflow"`
+	RunTotals
+	Messages []MessageCount `json:"messages"`
+}
+
+// JobMetrics holds the totals of the runs of a job
+type JobMetrics struct {
+	Repo     string `json:"repo"`
+	Workflow string `json:"workflow"`
+	Job      string `json:"job"`
+	RunTotals
+}
+
+// MessageCount is a group of equivalent error messages: Message is their
+// normalized form, Count their number and Examples some of the original
+// messages
+type MessageCount struct {
+	Message  string   `json:"message"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples"`
+}
+
+// successCounts holds the number of runs and successful runs of a group of
+// jobs
+type successCounts struct {
+	runs, successes int
+}
+
+func (c *successCounts) add(job JobRun) {
+	c.runs++
+	if job.Conclusion == "success" {
+		c.successes++
+	}
+}
+
+func (c successCounts) ratio() float64 {
+	return float64(c.successes) / float64(c.runs)
+}
+
+func (c successCounts) totals() RunTotals {
+	t := RunTotals{Runs: c.runs, Successes: c.successes, Failures: c.runs - c.successes}
+	if c.runs > 0 {
+		t.SuccessRate = c.ratio()
+	}
+	return t
+}
+
+// workflowMetrics holds the data the metrics of a workflow are computed from
+type workflowMetrics struct {
+	successCounts
+	messages []string
+}
+
+// getMetrics computes the metrics of the snapshot s
+func getMetrics(s *snapshot, opts reportOptions) *Metrics {
+	var global successCounts
+	repos := make(map[string]*successCounts)
+	workflows := make(map[[2]string]*workflowMetrics)
+	jobs := make(map[[3]string]*successCounts)
+	workflow := func(repo, name string) *workflowMetrics {
+		key := [2]string{repo, name}
+		if workflows[key] == nil {
+			workflows[key] = &workflowMetrics{}
+		}
+		return workflows[key]
+	}
+	for _, job := range s.Jobs {
+		global.add(job)
+		if repos[job.Repo] == nil {
+			repos[job.Repo] = &successCounts{}
+		}
+		repos[job.Repo].add(job)
+		workflow(job.Repo, job.Workflow).add(job)
+		key := [3]string{job.Repo, job.Workflow, job.Job}
+		if jobs[key] == nil {
+			jobs[key] = &successCounts{}
+		}
+		jobs[key].add(job)
+	}
+	for _, ann := range s.Annotations {
+		w := workflow(ann.Repo, ann.Workflow)
+		w.messages = append(w.messages, ann.Message)
+	}
+
+	m := &Metrics{
+		Version:   metricsVersion,
+		Window:    MetricsWindow{Since: s.Window.Since, Until: s.Window.Until},
+		Totals:    global.totals(),
+		Repos:     make([]RepoMetrics, 0, len(repos)),
+		Workflows: make([]WorkflowMetrics, 0, len(workflows)),
+		Jobs:      make([]JobMetrics, 0, len(jobs)),
+	}
+	if !s.FetchedAt.IsZero() {
+		fetchedAt := s.FetchedAt
+		m.FetchedAt = &fetchedAt
+	}
+	for repo, counts := range repos {
+		m.Repos = append(m.Repos, RepoMetrics{Repo: repo, RunTotals: counts.totals()})
+	}
+	sort.Slice(m.Repos, func(i, j int) bool {
+		return m.Repos[i].Repo < m.Repos[j].Repo
+	})
+
+	clusterer := opts.messageClusterer()
+	for key, w := range workflows {
+		messages := []MessageCount{}
+		for _, c := range clusterer.Cluster(w.messages) {
+			messages = append(messages, MessageCount{Message: c.Key, Count: c.Count, Examples: c.Examples})
+		}
+		m.Workflows = append(m.Workflows, WorkflowMetrics{
+			Repo:      key[0],
+			Workflow:  key[1],
+			RunTotals: w.totals(),
+			Messages:  messages,
+		})
+	}
+	sort.Slice(m.Workflows, func(i, j int) bool {
+		if m.Workflows[i].Repo != m.Workflows[j].Repo {
+			return m.Workflows[i].Repo < m.Workflows[j].Repo
+		}
+		return m.Workflows[i].Workflow < m.Workflows[j].Workflow
+	})
+
+	for key, counts := range jobs {
+		m.Jobs = append(m.Jobs, JobMetrics{Repo: key[0], Workflow: key[1], Job: key[2], RunTotals: counts.totals()})
+	}
+	sort.Slice(m.Jobs, func(i, j int) bool {
+		a, b := m.Jobs[i], m.Jobs[j]
+		if a.Repo != b.Repo {
+			return a.Repo < b.Repo
+		}
+		if a.Workflow != b.Workflow {
+			return a.Workflow < b.Workflow
+		}
+		return a.Job < b.Job
+	})
+	return m
+}
+
+// writeMetricsJSON writes the metrics of the report r to out as JSON
+func writeMetricsJSON(out io.Writer, r *report) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.metrics)
+}
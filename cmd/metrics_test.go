@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteMetricsJSON(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	job := func(repo, workflow, name, conclusion string) JobRun {
+		return JobRun{Repo: repo, Workflow: workflow, Job: name, Conclusion: conclusion}
+	}
+	jobs := []JobRun{
+		job("linkerd/linkerd2", "CI", "unit", "success"),
+		job("linkerd/linkerd2", "CI", "unit", "failure"),
+		job("linkerd/linkerd2", "CI", "integration", "timed_out"),
+		job("linkerd/linkerd2", "CI", "integration", "success"),
+		job("linkerd/linkerd2", "Docs", "build", "success"),
+		job("linkerd/linkerd2-proxy", "Rust", "test", "success"),
+	}
+	s := &snapshot{
+		FetchedAt: start.Add(24 * time.Hour),
+		Window:    window{Since: start, Until: start.Add(24 * time.Hour)},
+		Jobs:      jobs,
+		Annotations: []ErrorAnn{
+			{JobRun: jobs[1], Message: "TestUnit failed after 3s"},
+			{JobRun: jobs[2], Message: "TestUnit failed after 5s"},
+			{JobRun: jobs[2], Message: "TestInstall timed out"},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeMetricsJSON(&out, newReport(s, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	var m Metrics
+	if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != metricsVersion || m.FetchedAt == nil || !m.Window.Since.Equal(start) {
+		t.Errorf("unexpected header %+v", m)
+	}
+	if m.Totals != (RunTotals{Runs: 6, Successes: 4, Failures: 2, SuccessRate: 4.0 / 6}) {
+		t.Errorf("unexpected totals %+v", m.Totals)
+	}
+	if len(m.Repos) != 2 || m.Repos[0].Repo != "linkerd/linkerd2" || m.Repos[0].Runs != 5 || m.Repos[1].SuccessRate != 1 {
+		t.Errorf("unexpected repos %+v", m.Repos)
+	}
+
+	var workflows []string
+	for _, w := range m.Workflows {
+		workflows = append(workflows, w.Repo+" "+w.Workflow)
+	}
+	if len(workflows) != 3 || workflows[0] != "linkerd/linkerd2 CI" || workflows[1] != "linkerd/linkerd2 Docs" || workflows[2] != "linkerd/linkerd2-proxy Rust" {
+		t.Errorf("unexpected workflows %v", workflows)
+	}
+	ci := m.Workflows[0]
+	if ci.RunTotals != (RunTotals{Runs: 4, Successes: 2, Failures: 2, SuccessRate: 0.5}) {
+		t.Errorf("unexpected CI totals %+v", ci.RunTotals)
+	}
+	if len(ci.Messages) != 2 || ci.Messages[0].Message != "TestUnit failed after <duration>" || ci.Messages[0].Count != 2 ||
+		len(ci.Messages[0].Examples) != 2 || ci.Messages[1].Count != 1 {
+		t.Errorf("unexpected CI messages %+v", ci.Messages)
+	}
+	if m.Workflows[1].Messages == nil {
+		t.Error("expected an empty list of messages rather than null")
+	}
+
+	if len(m.Jobs) != 4 || m.Jobs[0].Job != "integration" || m.Jobs[1].Job != "unit" || m.Jobs[1].Failures != 1 {
+		t.Errorf("unexpected jobs %+v", m.Jobs)
+	}
+}
+
+func TestWriteMetricsJSONEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeMetricsJSON(&out, newReport(&snapshot{}, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"repos", "workflows", "jobs"} {
+		if list, ok := m[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("expected %s to be an empty list, got %v", key, m[key])
+		}
+	}
+	if _, ok := m["fetchedAt"]; ok {
+		t.Error("expected no fetchedAt when unknown")
+	}
+}
@@ -0,0 +1,258 @@
+// Package normalize groups together the error messages reporting the same
+// failure. Messages are first normalized by a list of regex replacement rules
+// masking the parts that change from one run to another (ids, addresses,
+// durations, pod names...), and the normalized messages that are similar
+// enough are then clustered.
+package normalize
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultThreshold is the default minimal similarity of two normalized
+	// messages for them to be clustered together
+	DefaultThreshold = 0.85
+	// DefaultMaxExamples is the default number of raw messages kept as
+	// examples of each cluster
+	DefaultMaxExamples = 3
+)
+
+// Rule replaces the matches of Pattern with Replacement, which can refer to
+// the submatches of the pattern as in regexp.Regexp.ReplaceAllString
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewRule compiles pattern into a Rule
+func NewRule(pattern, replacement string) (Rule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Pattern: re, Replacement: replacement}, nil
+}
+
+// podHash holds the characters Kubernetes generates the hashes and random
+// suffixes of pod names from
+const podHash = "[bcdfghjklmnpqrstvwxz2456789]"
+
+// Builtin holds the rules masking the values commonly found in the error
+// messages of the Go tests and of the Kubernetes tooling. They're applied in
+// order, so the more specific ones come first.
+var Builtin = []Rule{
+	// UUIDs
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	// timestamps, with or without a date
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(\.\d+)?\b`), "<time>"},
+	// pods of deployments (name-replicasethash-suffix) and of daemonsets or
+	// jobs (name-suffix)
+	{regexp.MustCompile(`\b([a-z0-9][-a-z0-9]*?)-` + podHash + `{6,10}-` + podHash + `{5}\b`), "$1-<pod>"},
+	{regexp.MustCompile(`\b([a-z0-9][-a-z0-9]*?)-` + podHash + `{5}\b`), "$1-<pod>"},
+	// IPv4 and IPv6 addresses, with an optional port
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`\[?\b[0-9a-fA-F]{1,4}(::?[0-9a-fA-F]{1,4}){2,7}\b(\]:\d+|\])?`), "<ip>"},
+	// Go durations, such as 1.5s or 2m30s, and spelled out ones
+	{regexp.MustCompile(`\b\d+(\.\d+)?(ns|us|µs|ms|s|m|h)(\d+(\.\d+)?(ns|us|µs|ms|s|m|h))*\b`), "<duration>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)? ?(nanoseconds?|microseconds?|milliseconds?|seconds?|minutes?|hours?)\b`), "<duration>"},
+	// hex numbers, commit SHAs and container ids
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<hex>"},
+	{regexp.MustCompile(`\b[0-9a-f]{7,64}\b`), "<hex>"},
+}
+
+// Normalizer rewrites messages so that the ones reporting the same failure
+// become equal
+type Normalizer interface {
+	Normalize(message string) string
+}
+
+// Rules is a Normalizer applying each one of its rules in turn
+type Rules []Rule
+
+// Default returns the builtin rules followed by the extra ones
+func Default(extra ...Rule) Rules {
+	rules := make(Rules, 0, len(Builtin)+len(extra))
+	rules = append(rules, Builtin...)
+	return append(rules, extra...)
+}
+
+// Normalize applies the rules to message
+func (r Rules) Normalize(message string) string {
+	for _, rule := range r {
+		message = rule.Pattern.ReplaceAllString(message, rule.Replacement)
+	}
+	return message
+}
+
+// Cluster is a group of messages that are similar once normalized
+type Cluster struct {
+	// Key is the most frequent normalized message of the cluster
+	Key string
+	// Count is the number of messages in the cluster
+	Count int
+	// Examples holds some of the raw messages of the cluster, from more to
+	// less frequent
+	Examples []string
+}
+
+// Clusterer groups messages into clusters
+type Clusterer struct {
+	Normalizer Normalizer
+	// Threshold is the minimal similarity, between 0 and 1, of two normalized
+	// messages for them to be clustered together. Similarity is the share of
+	// words left unchanged when editing one message into the other; with 1,
+	// only the messages that are equal once normalized are grouped.
+	Threshold float64
+	// MaxExamples is the number of distinct raw messages kept per cluster
+	MaxExamples int
+}
+
+// Cluster groups the messages, returning the clusters from larger to smaller
+func (c Clusterer) Cluster(messages []string) []Cluster {
+	// messages equal once normalized are grouped first, counting each one of
+	// the raw messages
+	type group struct {
+		key    string
+		words  []string
+		count  int
+		counts map[string]int
+	}
+	groups := make(map[string]*group)
+	for _, m := range messages {
+		key := m
+		if c.Normalizer != nil {
+			key = c.Normalizer.Normalize(m)
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{key: key, words: strings.Fields(key), counts: make(map[string]int)}
+			groups[key] = g
+		}
+		g.count++
+		g.counts[m]++
+	}
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	// each group then joins the first cluster whose leader, the largest group
+	// in it, is similar enough, or starts a new cluster
+	type cluster struct {
+		leader *group
+		count  int
+		counts map[string]int
+	}
+	var clusters []*cluster
+	for _, g := range sorted {
+		var joined *cluster
+		for _, cl := range clusters {
+			if similar(cl.leader.words, g.words, c.Threshold) {
+				joined = cl
+				break
+			}
+		}
+		if joined == nil {
+			joined = &cluster{leader: g, counts: make(map[string]int)}
+			clusters = append(clusters, joined)
+		}
+		joined.count += g.count
+		for m, n := range g.counts {
+			joined.counts[m] += n
+		}
+	}
+
+	result := make([]Cluster, len(clusters))
+	for i, cl := range clusters {
+		result[i] = Cluster{Key: cl.leader.key, Count: cl.count, Examples: examples(cl.counts, c.MaxExamples)}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Count > result[j].Count })
+	return result
+}
+
+// examples returns up to max of the messages, from more to less frequent
+func examples(counts map[string]int, max int) []string {
+	list := make([]string, 0, len(counts))
+	for m := range counts {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if counts[list[i]] != counts[list[j]] {
+			return counts[list[i]] > counts[list[j]]
+		}
+		return list[i] < list[j]
+	})
+	if len(list) > max {
+		list = list[:max]
+	}
+	return list
+}
+
+// similar tells whether the similarity of a and b is at least threshold. The
+// edit distance is at least the difference of length, which spares computing
+// it for messages of very different lengths.
+func similar(a, b []string, threshold float64) bool {
+	longest, diff := len(a), len(a)-len(b)
+	if len(b) > longest {
+		longest, diff = len(b), -diff
+	}
+	if longest > 0 && 1-float64(diff)/float64(longest) < threshold {
+		return false
+	}
+	return similarity(a, b) >= threshold
+}
+
+// similarity returns 1 minus the word-level edit distance between a and b,
+// relative to the length of the longest one
+func similarity(a, b []string) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(distance(a, b))/float64(longest)
+}
+
+// distance returns the Levenshtein distance between a and b, counting words
+// instead of characters
+func distance(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minimum(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
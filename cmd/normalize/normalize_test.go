@@ -0,0 +1,135 @@
+package normalize
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuiltin(t *testing.T) {
+	testCases := []struct {
+		message  string
+		expected string
+	}{
+		{
+			"request 123e4567-e89b-12d3-a456-426614174000 failed",
+			"request <uuid> failed",
+		},
+		{
+			"2020-06-01T10:20:30.123Z error reading logs",
+			"<time> error reading logs",
+		},
+		{
+			"pod linkerd-controller-6b8b4c7f5d-x2kqz is not ready",
+			"pod linkerd-controller-<pod> is not ready",
+		},
+		{
+			"pod linkerd-cni-4vx9p is not ready",
+			"pod linkerd-cni-<pod> is not ready",
+		},
+		{
+			"dial tcp 10.96.0.1:443: i/o timeout",
+			"dial tcp <ip>: i/o timeout",
+		},
+		{
+			"connecting to [fd00:10:96::1]:8086",
+			"connecting to <ip>",
+		},
+		{
+			"connecting to fd00:10:96:0:0:0:0:1",
+			"connecting to <ip>",
+		},
+		{
+			"TestInstall timed-out after 2m30.5s",
+			"TestInstall timed-out after <duration>",
+		},
+		{
+			"Back off 10.127 seconds before retry.",
+			"Back off <duration> before retry.",
+		},
+		{
+			"image cr.l5d.io/linkerd/proxy:git-3f2a9bc not found, checksum 0xDEADBEEF",
+			"image cr.l5d.io/linkerd/proxy:git-<hex> not found, checksum <hex>",
+		},
+		{
+			"TestEgress/e2e-k8s-1.20 failed: expected 3 replicas",
+			"TestEgress/e2e-k8s-1.20 failed: expected 3 replicas",
+		},
+	}
+
+	rules := Default()
+	for _, tc := range testCases {
+		if actual := rules.Normalize(tc.message); actual != tc.expected {
+			t.Errorf("normalizing %q: expected %q, got %q", tc.message, tc.expected, actual)
+		}
+	}
+}
+
+func TestDefaultExtraRules(t *testing.T) {
+	rule, err := NewRule(`run \d+`, "run <n>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Default(rule).Normalize("run 42 failed after 3s"); actual != "run <n> failed after <duration>" {
+		t.Errorf("unexpected normalized message: %q", actual)
+	}
+
+	if _, err := NewRule("(", ""); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	messages := []string{
+		"TestInstall failed after 1m2s: pod linkerd-web-7d9f6b5c8d-bcd2x not ready",
+		"TestInstall failed after 1m2s: pod linkerd-web-7d9f6b5c8d-bcd2x not ready",
+		"TestInstall failed after 3m10s: pod linkerd-web-5f7b8c9d6b-zz4kq not ready",
+		"TestInstall failed after 59s: pod linkerd-web-5f7b8c9d6b-q9r2t not ready",
+		// one word differs from the leader of the cluster above
+		"TestInstall failed after 45s: pod linkerd-web-5f7b8c9d6b-q9r2t not running",
+		"TestEgress failed",
+		"TestUpgrade failed",
+	}
+	c := Clusterer{Normalizer: Default(), Threshold: DefaultThreshold, MaxExamples: 2}
+	clusters := c.Cluster(messages)
+
+	expected := []Cluster{
+		{
+			Key:   "TestInstall failed after <duration>: pod linkerd-web-<pod> not ready",
+			Count: 5,
+			Examples: []string{
+				"TestInstall failed after 1m2s: pod linkerd-web-7d9f6b5c8d-bcd2x not ready",
+				"TestInstall failed after 3m10s: pod linkerd-web-5f7b8c9d6b-zz4kq not ready",
+			},
+		},
+		{Key: "TestEgress failed", Count: 1, Examples: []string{"TestEgress failed"}},
+		{Key: "TestUpgrade failed", Count: 1, Examples: []string{"TestUpgrade failed"}},
+	}
+	if !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("expected clusters:\n%+v\ngot:\n%+v", expected, clusters)
+	}
+
+	// with a threshold of 1, only the messages equal once normalized are
+	// grouped
+	c.Threshold = 1
+	if clusters := c.Cluster(messages); len(clusters) != 4 || clusters[0].Count != 4 {
+		t.Errorf("unexpected clusters: %+v", clusters)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	testCases := []struct {
+		a, b     []string
+		expected float64
+	}{
+		{nil, nil, 1},
+		{[]string{"a", "b"}, []string{"a", "b"}, 1},
+		{[]string{"a", "b", "c", "d"}, []string{"a", "x", "c", "d"}, 0.75},
+		{[]string{"a", "b", "c", "d"}, []string{"a", "c", "d"}, 0.75},
+		{[]string{"a"}, []string{"b", "c"}, 0},
+	}
+	for _, tc := range testCases {
+		if actual := similarity(tc.a, tc.b); actual != tc.expected {
+			t.Errorf("similarity of %v and %v: expected %v, got %v", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the buckets of the job
+// and workflow duration histograms
+var durationBuckets = []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200}
+
+// maxLabelLength is the length above which the error messages used as label
+// values are truncated
+const maxLabelLength = 200
+
+// promWriter writes metrics in the Prometheus text exposition format,
+// remembering the first write error
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// header writes the HELP and TYPE lines of a metric family
+func (p *promWriter) header(name, typ, help string) {
+	p.write("# HELP " + name + " " + help + "\n# TYPE " + name + " " + typ + "\n")
+}
+
+// sample writes a sample of the named metric. labels holds label names and
+// values in turn.
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(value))
+	b.WriteByte('\n')
+	p.write(b.String())
+}
+
+// histogram writes the buckets, sum and count of the durations
+func (p *promWriter) histogram(name string, durations []time.Duration, labels ...string) {
+	var sum float64
+	counts := make([]int, len(durationBuckets))
+	for _, d := range durations {
+		seconds := d.Seconds()
+		sum += seconds
+		for i, upper := range durationBuckets {
+			if seconds <= upper {
+				counts[i]++
+			}
+		}
+	}
+	for i, upper := range durationBuckets {
+		p.sample(name+"_bucket", float64(counts[i]), append(labels[:len(labels):len(labels)], "le", formatValue(upper))...)
+	}
+	p.sample(name+"_bucket", float64(len(durations)), append(labels[:len(labels):len(labels)], "le", "+Inf")...)
+	p.sample(name+"_sum", sum, labels...)
+	p.sample(name+"_count", float64(len(durations)), labels...)
+}
+
+func (p *promWriter) write(s string) {
+	if p.err == nil {
+		_, p.err = p.w.WriteString(s)
+	}
+}
+
+// escapeLabel escapes a label value as required by the exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// truncateLabel truncates value to maxLabelLength characters
+func truncateLabel(value string) string {
+	if runes := []rune(value); len(runes) > maxLabelLength {
+		return string(runes[:maxLabelLength]) + "..."
+	}
+	return value
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// writePrometheus writes the metrics of the report r to out in the
+// Prometheus text exposition format, as read by the textfile collector of the
+// node exporter. Success ratios, run counts and durations are labeled with
+// the repo, workflow and job they're about.
+func writePrometheus(out io.Writer, r *report) error {
+	s, m := r.snapshot, r.metrics
+
+	p := &promWriter{w: bufio.NewWriter(out)}
+	p.header("ci_window_start_timestamp_seconds", "gauge", "Start of the reporting window, in seconds since the epoch.")
+	p.sample("ci_window_start_timestamp_seconds", float64(s.Window.Since.Unix()))
+	p.header("ci_window_end_timestamp_seconds", "gauge", "End of the reporting window, in seconds since the epoch.")
+	p.sample("ci_window_end_timestamp_seconds", float64(s.Window.Until.Unix()))
+	if !s.FetchedAt.IsZero() {
+		p.header("ci_fetched_timestamp_seconds", "gauge", "When the data was fetched from Github, in seconds since the epoch.")
+		p.sample("ci_fetched_timestamp_seconds", float64(s.FetchedAt.Unix()))
+	}
+
+	if m.Totals.Runs > 0 {
+		p.header("ci_success_ratio", "gauge", "Share of the job runs that succeeded, over all the repos.")
+		p.sample("ci_success_ratio", m.Totals.SuccessRate)
+	}
+	p.header("ci_repo_success_ratio", "gauge", "Share of the job runs of the repo that succeeded.")
+	for _, repo := range m.Repos {
+		p.sample("ci_repo_success_ratio", repo.SuccessRate, "repo", repo.Repo)
+	}
+	p.header("ci_workflow_success_ratio", "gauge", "Share of the job runs of the workflow that succeeded.")
+	for _, w := range m.Workflows {
+		if w.Runs > 0 {
+			p.sample("ci_workflow_success_ratio", w.SuccessRate, "repo", w.Repo, "workflow", w.Workflow)
+		}
+	}
+	p.header("ci_job_success_ratio", "gauge", "Share of the runs of the job that succeeded.")
+	for _, j := range m.Jobs {
+		p.sample("ci_job_success_ratio", j.SuccessRate, "repo", j.Repo, "workflow", j.Workflow, "job", j.Job)
+	}
+	p.header("ci_job_runs", "gauge", "Number of runs of the job in the reporting window.")
+	for _, j := range m.Jobs {
+		p.sample("ci_job_runs", float64(j.Runs), "repo", j.Repo, "workflow", j.Workflow, "job", j.Job)
+	}
+	p.header("ci_job_successes", "gauge", "Number of successful runs of the job in the reporting window.")
+	for _, j := range m.Jobs {
+		p.sample("ci_job_successes", float64(j.Successes), "repo", j.Repo, "workflow", j.Workflow, "job", j.Job)
+	}
+
+	p.header("ci_failure_messages", "gauge", "Number of error messages of the failed jobs of the workflow, grouped by normalized message.")
+	for _, w := range m.Workflows {
+		// clusters whose keys are the same once truncated are merged, as
+		// series can't be repeated
+		var messages []string
+		counts := make(map[string]int)
+		for _, c := range w.Messages {
+			message := truncateLabel(c.Message)
+			if _, ok := counts[message]; !ok {
+				messages = append(messages, message)
+			}
+			counts[message] += c.Count
+		}
+		for _, message := range messages {
+			p.sample("ci_failure_messages", float64(counts[message]), "repo", w.Repo, "workflow", w.Workflow, "message", message)
+		}
+	}
+
+	p.header("ci_job_duration_seconds", "histogram", "Duration of the runs of the job.")
+	for _, j := range m.Jobs {
+		if durations := r.jobDurations[[3]string{j.Repo, j.Workflow, j.Job}]; len(durations) > 0 {
+			p.histogram("ci_job_duration_seconds", durations, "repo", j.Repo, "workflow", j.Workflow, "job", j.Job)
+		}
+	}
+	p.header("ci_workflow_duration_seconds", "histogram", "Duration of the runs of the workflow, from the start of their first job to the completion of their last one.")
+	for _, w := range m.Workflows {
+		if durations := r.workflowDurations[[2]string{w.Repo, w.Workflow}]; len(durations) > 0 {
+			p.histogram("ci_workflow_duration_seconds", durations, "repo", w.Repo, "workflow", w.Workflow)
+		}
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
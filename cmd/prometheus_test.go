@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	job := func(workflow, name, conclusion string, runID int64, minutes int) JobRun {
+		return JobRun{
+			Repo:       "linkerd/linkerd2",
+			Workflow:   workflow,
+			Job:        name,
+			RunID:      runID,
+			RunAttempt: 1,
+			Conclusion: conclusion,
+			Started:    github.Timestamp{Time: start},
+			Completed:  github.Timestamp{Time: start.Add(time.Duration(minutes) * time.Minute)},
+		}
+	}
+	jobs := []JobRun{
+		job("CI", "unit", "success", 1, 3),
+		job("CI", "unit", "failure", 2, 4),
+		job("CI", "integration", "success", 1, 30),
+		job("CI", "integration", "success", 2, 90),
+	}
+	s := &snapshot{
+		Window: window{Since: start, Until: start.Add(24 * time.Hour)},
+		Jobs:   jobs,
+		Annotations: []ErrorAnn{
+			{JobRun: jobs[1], Message: "TestUnit \"quoted\" failed after 3s"},
+			{JobRun: jobs[1], Message: "TestUnit \"quoted\" failed after 5s"},
+			{JobRun: jobs[1], Message: "TestOther failed"},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writePrometheus(&out, newReport(s, reportOptions{})); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	expected := []string{
+		"# TYPE ci_success_ratio gauge",
+		"ci_success_ratio 0.75",
+		`ci_repo_success_ratio{repo="linkerd/linkerd2"} 0.75`,
+		`ci_workflow_success_ratio{repo="linkerd/linkerd2",workflow="CI"} 0.75`,
+		`ci_job_success_ratio{repo="linkerd/linkerd2",workflow="CI",job="unit"} 0.5`,
+		`ci_job_runs{repo="linkerd/linkerd2",workflow="CI",job="integration"} 2`,
+		`ci_job_successes{repo="linkerd/linkerd2",workflow="CI",job="unit"} 1`,
+		`ci_failure_messages{repo="linkerd/linkerd2",workflow="CI",message="TestUnit \"quoted\" failed after <duration>"} 2`,
+		`ci_failure_messages{repo="linkerd/linkerd2",workflow="CI",message="TestOther failed"} 1`,
+		"# TYPE ci_job_duration_seconds histogram",
+		`ci_job_duration_seconds_bucket{repo="linkerd/linkerd2",workflow="CI",job="integration",le="1800"} 1`,
+		`ci_job_duration_seconds_bucket{repo="linkerd/linkerd2",workflow="CI",job="integration",le="5400"} 2`,
+		`ci_job_duration_seconds_bucket{repo="linkerd/linkerd2",workflow="CI",job="integration",le="+Inf"} 2`,
+		`ci_job_duration_seconds_sum{repo="linkerd/linkerd2",workflow="CI",job="integration"} 7200`,
+		`ci_job_duration_seconds_count{repo="linkerd/linkerd2",workflow="CI",job="integration"} 2`,
+		// runs 1 and 2 took as long as their integration job
+		`ci_workflow_duration_seconds_bucket{repo="linkerd/linkerd2",workflow="CI",le="1800"} 1`,
+		`ci_workflow_duration_seconds_count{repo="linkerd/linkerd2",workflow="CI"} 2`,
+		"ci_window_start_timestamp_seconds 1590969600",
+	}
+	for _, e := range expected {
+		found := false
+		for _, line := range lines {
+			if line == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected line %s in:\n%s", e, out.String())
+		}
+	}
+
+	// each series appears once
+	series := make(map[string]bool)
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := line[:strings.LastIndex(line, " ")]
+		if series[name] {
+			t.Errorf("series %s is repeated", name)
+		}
+		series[name] = true
+	}
+}
+
+func TestTruncateLabel(t *testing.T) {
+	long := strings.Repeat("é", maxLabelLength+10)
+	if l := truncateLabel(long); l != strings.Repeat("é", maxLabelLength)+"..." {
+		t.Errorf("unexpected truncated label %q", l)
+	}
+	if l := escapeLabel("a\\b\n\"c\""); l != `a\\b\n\"c\"` {
+		t.Errorf("unexpected escaped label %q", l)
+	}
+}
@@ -0,0 +1,143 @@
+// Package ratelimit implements a token bucket rate limiter meant to be shared
+// by all the goroutines sending requests to the Github API, so that together
+// they stay within the API's hourly budget. The pace can be adapted on the fly
+// according to the budget reported by the API.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter hands out one token every interval, allowing bursts of up to burst
+// requests
+type Limiter struct {
+	mu          sync.Mutex
+	minInterval time.Duration
+	interval    time.Duration
+	burst       int
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// New returns a Limiter allowing one request every interval, with bursts of
+// up to burst requests. The bucket starts full. interval is also the minimum
+// interval the Limiter can be adapted to.
+func New(interval time.Duration, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		minInterval: interval,
+		interval:    interval,
+		burst:       burst,
+		tokens:      float64(burst),
+		last:        time.Now(),
+	}
+}
+
+// PerHour returns a Limiter spreading requests evenly so that no more than
+// requests are sent in an hour
+func PerHour(requests, burst int) *Limiter {
+	if requests < 1 {
+		requests = 1
+	}
+	return New(time.Hour/time.Duration(requests), burst)
+}
+
+// Wait blocks until a token is available or ctx is done, in which case the
+// context's error is returned
+func (l *Limiter) Wait(ctx context.Context) error {
+	delay := l.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Adapt spreads the remaining requests evenly over the time left until reset,
+// without going faster than the interval the Limiter was created with. It
+// returns the new interval, and whether it differs by more than 10% from the
+// previous one.
+func (l *Limiter) Adapt(remaining int, reset time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Until(reset)
+	if until <= 0 {
+		l.interval = l.minInterval
+		return l.interval, false
+	}
+	if remaining < 1 {
+		remaining = 1
+	}
+	interval := until / time.Duration(remaining)
+	if interval < l.minInterval {
+		interval = l.minInterval
+	}
+
+	previous := l.interval
+	l.refill(time.Now())
+	l.interval = interval
+	diff := interval - previous
+	if diff < 0 {
+		diff = -diff
+	}
+	return interval, diff*10 > previous
+}
+
+// PauseUntil holds all the requests until t
+func (l *Limiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.After(l.pausedUntil) {
+		l.pausedUntil = t
+	}
+}
+
+// reserve takes a token from the bucket, returning how long the caller has to
+// wait until that token becomes available
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	start := now
+	if l.pausedUntil.After(now) {
+		start = l.pausedUntil
+	}
+	l.refill(start)
+
+	l.tokens--
+	wait := start.Sub(now)
+	if l.tokens < 0 {
+		wait += time.Duration(-l.tokens * float64(l.interval))
+	}
+	return wait
+}
+
+// refill adds the tokens accumulated since the last refill up to t
+func (l *Limiter) refill(t time.Time) {
+	if !t.After(l.last) {
+		return
+	}
+	if l.interval > 0 {
+		l.tokens += float64(t.Sub(l.last)) / float64(l.interval)
+	} else {
+		l.tokens = float64(l.burst)
+	}
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = t
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	l := New(20*time.Millisecond, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.Wait(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the first two requests are served by the burst, the remaining four
+	// need one interval each
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected at least 80ms to serve 6 requests, took %s", elapsed)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := New(time.Hour, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := l.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLimiterAdapt(t *testing.T) {
+	l := New(10*time.Millisecond, 1)
+
+	interval, changed := l.Adapt(10, time.Now().Add(time.Second))
+	if !changed || interval < 90*time.Millisecond || interval > 100*time.Millisecond {
+		t.Errorf("expected the interval to change to ~100ms, got %s (changed: %t)", interval, changed)
+	}
+
+	// plenty of budget left, but the limiter doesn't go below its
+	// initial interval
+	interval, _ = l.Adapt(1000000, time.Now().Add(time.Second))
+	if interval != 10*time.Millisecond {
+		t.Errorf("expected the interval to be 10ms, got %s", interval)
+	}
+}
+
+func TestLimiterPauseUntil(t *testing.T) {
+	l := New(time.Millisecond, 5)
+	l.PauseUntil(time.Now().Add(50 * time.Millisecond))
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("expected to wait for the pause to end, waited %s", elapsed)
+	}
+}
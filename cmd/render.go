@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// report holds what reports are rendered from: the snapshot and the options,
+// along with the model computed from them. The model is built once and shared
+// by all the renderers, which only read it, so that new formats can be added
+// without touching the aggregation code.
+type report struct {
+	snapshot *snapshot
+	opts     reportOptions
+
+	// metrics holds the success rates and the error messages, and previous
+	// those of opts.previous, nil if not set
+	metrics  *Metrics
+	previous *Metrics
+
+	// jobDurations and workflowDurations hold the durations of the runs of
+	// each job and workflow, keyed by repo, workflow and job, and jobStats
+	// and workflowStats their distribution
+	jobDurations      map[[3]string][]time.Duration
+	workflowDurations map[[2]string][]time.Duration
+	jobStats          map[[3]string]DurationStats
+	workflowStats     map[[2]string]DurationStats
+	// jobDurationStats holds the distribution of the durations of the jobs,
+	// whatever their workflow, and workflowDurationStats the one of the
+	// workflows, from slowest to fastest median, named as in the html report;
+	// slowdowns holds the jobs that got slower since opts.previous
+	jobDurationStats      []DurationStats
+	workflowDurationStats []DurationStats
+	slowdowns             []Slowdown
+
+	trends       Trends
+	testFailures []TestFailures
+	hotspotFiles []Hotspot
+	hotspotLines []Hotspot
+	flakyTests   []Flakiness
+	flakyJobs    []Flakiness
+
+	// summaryBefore and summaryAfter hold the totals whose success rates are
+	// compared in the Markdown summary, nil if none are
+	summaryBefore *periodTotals
+	summaryAfter  *periodTotals
+}
+
+// newReport computes the model of the snapshot s, comparing it against the
+// previous snapshot of opts if any
+func newReport(s *snapshot, opts reportOptions) *report {
+	r := &report{
+		snapshot:          s,
+		opts:              opts,
+		metrics:           getMetrics(s, opts),
+		jobDurations:      jobRunDurations(s.Jobs),
+		workflowDurations: workflowRunDurations(s.Jobs),
+		jobStats:          make(map[[3]string]DurationStats),
+		workflowStats:     make(map[[2]string]DurationStats),
+	}
+	multiRepo := r.multiRepo()
+	for key, durations := range r.jobDurations {
+		r.jobStats[key] = durationStats(durations)
+	}
+	for key, durations := range r.workflowDurations {
+		r.workflowStats[key] = durationStats(durations)
+	}
+	r.jobDurationStats = getJobDurations(r.jobDurations, multiRepo)
+	r.workflowDurationStats = getWorkflowDurations(r.workflowDurations, multiRepo)
+	if opts.previous != nil {
+		r.previous = getMetrics(opts.previous, opts)
+		previousDurations := getJobDurations(jobRunDurations(opts.previous.Jobs), multiRepo)
+		r.slowdowns = getSlowdowns(previousDurations, r.jobDurationStats, opts.slowdownThreshold)
+	}
+
+	r.trends = getTrends(s.Jobs, s.Window, opts.bucket, multiRepo)
+	r.testFailures = getTestFailures(s.Annotations)
+	r.hotspotFiles, r.hotspotLines = getHotspots(s.Annotations, githubWebURL(s.APIURL))
+	r.flakyTests = getFlakyMessages(s.Jobs, s.Annotations, opts.messageClusterer().Normalizer)
+	r.flakyJobs = getFlakyJobs(s.Jobs)
+	r.summaryBefore, r.summaryAfter = getSummaryPeriods(s, r.metrics, r.previous)
+	return r
+}
+
+// multiRepo tells whether the report covers more than one repo, in which case
+// the workflow and job names are qualified by their repo
+func (r *report) multiRepo() bool {
+	return len(r.metrics.Repos) > 1
+}
+
+// Renderer renders reports in an output format. New formats are added by
+// registering a Renderer in renderers.
+type Renderer interface {
+	// ContentType returns the media type of the output, as served over HTTP
+	ContentType() string
+	// Render writes the report r to out
+	Render(out io.Writer, r *report) error
+}
+
+// rendererFunc is a Renderer calling render
+type rendererFunc struct {
+	contentType string
+	render      func(io.Writer, *report) error
+}
+
+func (f rendererFunc) ContentType() string {
+	return f.contentType
+}
+
+func (f rendererFunc) Render(out io.Writer, r *report) error {
+	return f.render(out, r)
+}
+
+// csvContentType is the media type of the CSV tables
+const csvContentType = "text/csv; charset=utf-8"
+
+// renderers maps the names of the formats the report command renders, as
+// given through its -format flag, to their Renderer
+var renderers = map[string]Renderer{
+	"html":                rendererFunc{"text/html; charset=utf-8", processData},
+	"json":                rendererFunc{"application/json", writeMetricsJSON},
+	"markdown":            rendererFunc{"text/markdown; charset=utf-8", writeMarkdown},
+	"prometheus":          rendererFunc{"text/plain; version=0.0.4; charset=utf-8", writePrometheus},
+	"csv-jobs":            rendererFunc{csvContentType, writeJobsCSV},
+	"csv-annotations":     rendererFunc{csvContentType, writeAnnotationsCSV},
+	"csv-job-totals":      rendererFunc{csvContentType, writeJobTotalsCSV},
+	"csv-workflow-totals": rendererFunc{csvContentType, writeWorkflowTotalsCSV},
+}
+
+// formatNames returns the sorted names of the report formats
+func formatNames() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// renderFile renders the report r with the renderer to the file at path,
+// which is replaced at once once complete, or to stdout if path is empty
+func renderFile(renderer Renderer, r *report, path string) error {
+	if path == "" {
+		return renderer.Render(os.Stdout, r)
+	}
+	return writeFileAtomically(path, func(w io.Writer) error {
+		return renderer.Render(w, r)
+	})
+}
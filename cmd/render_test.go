@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderers(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []JobRun{
+		{Repo: "linkerd/linkerd2", Workflow: "KinD integration", Job: "unit", CheckRunID: 1, Conclusion: "success"},
+		{Repo: "linkerd/linkerd2", Workflow: "KinD integration", Job: "unit", CheckRunID: 2, Conclusion: "failure"},
+	}
+	s := &snapshot{
+		Window:      window{Since: start, Until: start.Add(24 * time.Hour)},
+		Jobs:        jobs,
+		Annotations: []ErrorAnn{{JobRun: jobs[1], Message: "TestUnit failed"}},
+	}
+	r := newReport(s, reportOptions{previous: &snapshot{Jobs: jobs[:1]}})
+	if r.metrics.Totals.Runs != 2 || r.previous == nil || r.previous.Totals.Runs != 1 {
+		t.Fatalf("unexpected metrics %+v, previous %+v", r.metrics, r.previous)
+	}
+
+	for _, name := range formatNames() {
+		renderer := renderers[name]
+		if renderer.ContentType() == "" {
+			t.Errorf("%s: expected a content type", name)
+		}
+		var out bytes.Buffer
+		if err := renderer.Render(&out, r); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !strings.Contains(out.String(), "KinD integration") {
+			t.Errorf("%s: expected the workflow in:\n%s", name, out.String())
+		}
+	}
+
+	// the html report is titled after the repos covered
+	var out bytes.Buffer
+	if err := renderers["html"].Render(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<title>linkerd/linkerd2 CI Metrics</title>") {
+		t.Errorf("expected the html report to be titled after linkerd/linkerd2")
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	jobs := []JobRun{
+		timedJob("unit", 1, start, 0, 5),
+		timedJob("integration", 1, start, 1, 20),
+		timedJob("integration", 2, start, 60, 30),
+	}
+	s := &snapshot{Window: window{Since: start, Until: start.Add(24 * time.Hour)}, Jobs: jobs}
+	previous := &snapshot{Jobs: []JobRun{timedJob("integration", 0, start, 0, 15)}}
+	r := newReport(s, reportOptions{previous: previous, slowdownThreshold: defaultSlowdownThreshold})
+
+	key := [3]string{"linkerd/linkerd2", "CI", "integration"}
+	if len(r.jobDurations[key]) != 2 || r.jobStats[key].P50 != 20*time.Minute || r.jobStats[key].Max != 30*time.Minute {
+		t.Errorf("unexpected durations of the integration job %v, %+v", r.jobDurations[key], r.jobStats[key])
+	}
+	if stats := r.workflowStats[[2]string{"linkerd/linkerd2", "CI"}]; stats.Runs != 2 {
+		t.Errorf("expected 2 workflow runs, got %+v", stats)
+	}
+	if len(r.jobDurationStats) != 2 || len(r.workflowDurationStats) != 1 {
+		t.Errorf("unexpected duration stats %+v and %+v", r.jobDurationStats, r.workflowDurationStats)
+	}
+	if len(r.slowdowns) != 1 || r.slowdowns[0].Name != "integration" {
+		t.Errorf("unexpected slowdowns %+v", r.slowdowns)
+	}
+	if len(r.trends.Buckets) == 0 {
+		t.Errorf("expected trends, got %+v", r.trends)
+	}
+
+	// rendering leaves the model as is, as it's shared by the renderers
+	durations := append([]time.Duration(nil), r.jobDurations[key]...)
+	for _, name := range formatNames() {
+		if err := renderers[name].Render(ioutil.Discard, r); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+	if !reflect.DeepEqual(durations, r.jobDurations[key]) {
+		t.Errorf("expected the durations to be left as is, got %v instead of %v", r.jobDurations[key], durations)
+	}
+}
+
+func TestRenderFile(t *testing.T) {
+	var rendered *report
+	renderer := rendererFunc{"text/plain", func(out io.Writer, r *report) error {
+		rendered = r
+		_, err := io.WriteString(out, "rendered")
+		return err
+	}}
+	r := newReport(&snapshot{}, reportOptions{})
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := renderFile(renderer, r, path); err != nil {
+		t.Fatal(err)
+	}
+	if rendered != r {
+		t.Errorf("expected the report to be rendered as is")
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "rendered" {
+		t.Errorf("expected %q, got %q", "rendered", content)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseBackoff = time.Second
+	maxBackoff         = time.Minute
+)
+
+// primaryRateLimitWait returns how long to wait for the primary rate limit to
+// be reset, if err is due to it having been exhausted
+func primaryRateLimitWait(err error) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return time.Until(rateErr.Rate.Reset.Time) + resetMargin, true
+	}
+	return 0, false
+}
+
+// secondaryRateLimitWait returns how long Github asks to wait before
+// retrying, if err is due to the secondary (abuse) rate limit
+func secondaryRateLimitWait(resp *github.Response, err error) (time.Duration, bool) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return defaultRetryAfter, true
+	}
+	if err != nil && resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		return defaultRetryAfter, true
+	}
+	return 0, false
+}
+
+// isRetryable tells whether err is a transient failure worth retrying: 5xx
+// responses, the secondary rate limit, timeouts and connections dropped by
+// the server. Any other error, like a 401 or a 404, is considered permanent.
+func isRetryable(resp *github.Response, err error) bool {
+	if _, ok := secondaryRateLimitWait(resp, err); ok {
+		return true
+	}
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) {
+		return errResp.Response != nil && errResp.Response.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// backoff returns the time to wait before the given retry attempt (starting
+// at 1), doubling base on each attempt up to maxBackoff, with jitter so that
+// concurrent requests failing at the same time don't retry in lockstep
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	// pick a value in [d/2, d)
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// sleep waits for d or until ctx is done, whatever happens first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// describeError wraps err with a description of the request that caused it,
+// adding a hint for the errors that usually come from a misconfiguration
+func describeError(err error, request string) error {
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil {
+		switch errResp.Response.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("%s: %w (check that the %s env var holds a valid token)", request, err, tokenLabel)
+		case http.StatusNotFound:
+			return fmt.Errorf("%s: %w (check that the repo and workflow file exist and the token can access them)", request, err)
+		}
+	}
+	return fmt.Errorf("%s: %w", request, err)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/httpcache"
+)
+
+const (
+	// defaultRefreshInterval is the default interval between two fetches of
+	// the data served
+	defaultRefreshInterval = time.Hour
+	// reloadInterval is the interval between two checks for changes of the
+	// snapshot file served when the data isn't fetched by the server itself
+	reloadInterval = 10 * time.Second
+	// shutdownTimeout is how long in-flight requests are waited for on
+	// shutdown
+	shutdownTimeout = 10 * time.Second
+)
+
+// source returns the latest data to serve, or a nil snapshot if it didn't
+// change since the previous call
+type source func(ctx context.Context) (*snapshot, error)
+
+// server serves the report and the metrics rendered from the latest data. The
+// data is refreshed in the background and swapped at once, so requests keep
+// being served from the previous data while a refresh is in progress.
+type server struct {
+	opts reportOptions
+	// webhook, if set, receives the Github webhook deliveries at /webhook
+	webhook http.Handler
+
+	mu       sync.RWMutex
+	snapshot *snapshot
+	// report is built from snapshot on the first request rendering it
+	report *report
+	// received holds the jobs added since the latest refresh started, along
+	// with their annotations, which the data it loads may lack
+	received            []JobRun
+	receivedAnnotations []ErrorAnn
+	lastRefresh         time.Time
+	lastErr             error
+}
+
+func newServer(opts reportOptions) *server {
+	return &server{opts: opts}
+}
+
+// data returns the snapshot currently served, nil if none was loaded yet
+func (s *server) data() *snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshot
+}
+
+// update swaps the snapshot served, adding the jobs received since the
+// latest refresh started that snap lacks
+func (s *server) update(snap *snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshot = withJobs(snap, s.received, s.receivedAnnotations)
+	s.report = nil
+	s.lastRefresh = time.Now()
+	s.lastErr = nil
+}
+
+// hasJob tells whether the job for checkRunID is in the data served
+func (s *server) hasJob(checkRunID int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.snapshot == nil {
+		return false
+	}
+	for _, job := range s.snapshot.Jobs {
+		if job.CheckRunID == checkRunID {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the jobs that aren't in the data served yet, along with their
+// annotations, extending the window up to now. Until data is loaded, the jobs
+// are only kept for the first refresh to add them.
+func (s *server) add(jobs []JobRun, annotations []ErrorAnn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, jobs...)
+	s.receivedAnnotations = append(s.receivedAnnotations, annotations...)
+	if s.snapshot == nil {
+		return
+	}
+	if snap := withJobs(s.snapshot, jobs, annotations); snap != s.snapshot {
+		s.snapshot = snap
+		s.report = nil
+	}
+}
+
+// withJobs returns a copy of snap to which the jobs it lacks are added, along
+// with their annotations, extending the window up to now. snap is returned as
+// is if it has all the jobs already.
+func withJobs(snap *snapshot, jobs []JobRun, annotations []ErrorAnn) *snapshot {
+	known := make(map[int64]bool, len(snap.Jobs))
+	for _, job := range snap.Jobs {
+		known[job.CheckRunID] = true
+	}
+	added := make(map[int64]bool, len(jobs))
+	var newJobs []JobRun
+	for _, job := range jobs {
+		if !known[job.CheckRunID] && !added[job.CheckRunID] {
+			newJobs = append(newJobs, job)
+			added[job.CheckRunID] = true
+		}
+	}
+	if len(newJobs) == 0 {
+		return snap
+	}
+	var newAnnotations []ErrorAnn
+	for _, ann := range annotations {
+		if added[ann.CheckRunID] {
+			newAnnotations = append(newAnnotations, ann)
+		}
+	}
+
+	// the snapshot served is never modified in place, as it may be in use by
+	// the requests in flight; the most recent jobs come first, as fetched
+	merged := *snap
+	merged.Jobs = append(newJobs, snap.Jobs...)
+	merged.Annotations = append(newAnnotations, snap.Annotations...)
+	if now := time.Now(); now.After(merged.Window.Until) {
+		merged.Window.Until = now
+	}
+	return &merged
+}
+
+// refresh loads new data from src, keeping the current data if that fails.
+// The jobs added while it's in progress are added to the new data if it lacks
+// them, as src may have read their workflow's runs before they were added.
+func (s *server) refresh(ctx context.Context, src source) {
+	s.mu.Lock()
+	s.received, s.receivedAnnotations = nil, nil
+	s.mu.Unlock()
+	snap, err := src(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("refresh failed: %s", err)
+		}
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		return
+	}
+	if snap == nil {
+		s.mu.Lock()
+		s.lastErr = nil
+		s.mu.Unlock()
+		return
+	}
+	s.update(snap)
+	log.Printf("refreshed: %d jobs and %d annotations", len(snap.Jobs), len(snap.Annotations))
+}
+
+// refreshLoop refreshes the data from src every interval, starting after
+// delay, until ctx is done
+func (s *server) refreshLoop(ctx context.Context, src source, delay, interval time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		s.refresh(ctx, src)
+		timer.Reset(interval)
+	}
+}
+
+// handler returns the handler serving the html report at /, the Prometheus
+// metrics at /metrics, the computed metrics and the data as JSON under /api/,
+// the health status at /healthz and, if enabled, receiving the webhook
+// deliveries at /webhook
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		s.render(w, renderers["html"])
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		s.render(w, renderers["prometheus"])
+	})
+	mux.HandleFunc("/api/metrics", func(w http.ResponseWriter, r *http.Request) {
+		s.render(w, renderers["json"])
+	})
+	mux.HandleFunc("/api/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		s.serveJSON(w, func(snap *snapshot) interface{} { return snap })
+	})
+	mux.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		s.serveJSON(w, func(snap *snapshot) interface{} { return snap.Jobs })
+	})
+	mux.HandleFunc("/api/annotations", func(w http.ResponseWriter, r *http.Request) {
+		s.serveJSON(w, func(snap *snapshot) interface{} { return snap.Annotations })
+	})
+	mux.HandleFunc("/healthz", s.healthz)
+	if s.webhook != nil {
+		mux.Handle("/webhook", s.webhook)
+	}
+	return mux
+}
+
+// currentReport returns the report of the data served, nil if none was loaded
+// yet. It's built once per snapshot, and then shared by the requests. It's
+// built without holding the lock, so that the other requests aren't held up
+// meanwhile, and kept only if the data didn't change in the meantime.
+func (s *server) currentReport() *report {
+	s.mu.RLock()
+	snap, r := s.snapshot, s.report
+	s.mu.RUnlock()
+	if r != nil || snap == nil {
+		return r
+	}
+
+	r = newReport(snap, s.opts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshot == snap && s.report == nil {
+		s.report = r
+	}
+	return r
+}
+
+// render writes the report of the data served rendered by the renderer,
+// rendering it first so that failures can be reported with an error status
+func (s *server) render(w http.ResponseWriter, renderer Renderer) {
+	r := s.currentReport()
+	if r == nil {
+		http.Error(w, "no data loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+	var buf bytes.Buffer
+	if err := renderer.Render(&buf, r); err != nil {
+		log.Print(err)
+		http.Error(w, "failed to render the data", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", renderer.ContentType())
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Print(err)
+	}
+}
+
+// serveJSON writes the value returned by fn for the data served as JSON
+func (s *server) serveJSON(w http.ResponseWriter, fn func(*snapshot) interface{}) {
+	snap := s.data()
+	if snap == nil {
+		http.Error(w, "no data loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, http.StatusOK, fn(snap))
+}
+
+// health is the status reported by /healthz. LastRefresh is when the data was
+// last loaded successfully, and LastError the error of the latest refresh, if
+// it failed.
+type health struct {
+	Status      string
+	LastRefresh *time.Time `json:",omitempty"`
+	FetchedAt   *time.Time `json:",omitempty"`
+	LastError   string     `json:",omitempty"`
+}
+
+// healthz reports whether there's data to serve, and when it was last
+// refreshed
+func (s *server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	h := health{Status: "ok"}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
+	}
+	status := http.StatusOK
+	if s.snapshot == nil {
+		h.Status = "no data loaded yet"
+		status = http.StatusServiceUnavailable
+	} else {
+		lastRefresh, fetchedAt := s.lastRefresh, s.snapshot.FetchedAt
+		h.LastRefresh = &lastRefresh
+		if !fetchedAt.IsZero() {
+			h.FetchedAt = &fetchedAt
+		}
+	}
+	s.mu.RUnlock()
+	writeJSON(w, status, h)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "failed to encode the data", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		log.Print(err)
+	}
+}
+
+// serve serves the handler on l until ctx is done, then waits for the
+// in-flight requests to complete before returning
+func (s *server) serve(ctx context.Context, l net.Listener) error {
+	srv := &http.Server{Handler: s.handler()}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// fetchSource returns a source fetching the data from Github with the fetcher
+// f according to the fetch flags, saving it to the snapshot file at path if
+// not empty. The stats of the cache are logged after each fetch if not nil.
+func fetchSource(ff *fetchFlags, f *fetcher, cache *httpcache.Transport, path string) source {
+	return func(ctx context.Context) (*snapshot, error) {
+		snap, err := ff.fetchWith(ctx, f, cache)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			if err := writeSnapshot(path, snap); err != nil {
+				log.Printf("failed to save the snapshot: %s", err)
+			}
+		}
+		return snap, nil
+	}
+}
+
+// fileSource returns a source reading the snapshot file at path whenever its
+// modification time changes
+func fileSource(path string) source {
+	var modTime time.Time
+	return func(ctx context.Context) (*snapshot, error) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Equal(modTime) {
+			return nil, nil
+		}
+		snap, err := readSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		modTime = info.ModTime()
+		return snap, nil
+	}
+}
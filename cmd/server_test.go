@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/fakegithub"
+)
+
+// get sends a GET request for path to h, returning the response status and
+// body
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestServerHandler(t *testing.T) {
+	srv := newServer(reportOptions{})
+	h := srv.handler()
+	for _, path := range []string{"/", "/metrics", "/api/jobs", "/healthz"} {
+		if code, _ := get(t, h, path); code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %d before any data is loaded, got %d", path, http.StatusServiceUnavailable, code)
+		}
+	}
+
+	jobs := []JobRun{
+		{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", Conclusion: "success"},
+		{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", Conclusion: "failure"},
+	}
+	fetchedAt := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	srv.update(&snapshot{
+		FetchedAt:   fetchedAt,
+		Window:      defaultWindow(fetchedAt),
+		Jobs:        jobs,
+		Annotations: []ErrorAnn{{JobRun: jobs[1], Message: "TestUnit failed"}},
+	})
+
+	if code, body := get(t, h, "/"); code != http.StatusOK || !strings.Contains(body, "<html") {
+		t.Errorf("expected the html report, got %d: %.100s", code, body)
+	}
+	if code, body := get(t, h, "/metrics"); code != http.StatusOK || !strings.Contains(body, `ci_job_runs{repo="linkerd/linkerd2",workflow="CI",job="unit"} 2`) {
+		t.Errorf("expected the metrics, got %d: %s", code, body)
+	}
+	code, body := get(t, h, "/api/metrics")
+	var metrics Metrics
+	if err := json.Unmarshal([]byte(body), &metrics); err != nil || code != http.StatusOK {
+		t.Fatalf("expected the metrics as JSON, got %d: %s", code, body)
+	}
+	if metrics.Totals.Runs != 2 || len(metrics.Jobs) != 1 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+	code, body = get(t, h, "/api/annotations")
+	var annotations []ErrorAnn
+	if err := json.Unmarshal([]byte(body), &annotations); err != nil || code != http.StatusOK {
+		t.Fatalf("expected the annotations, got %d: %s", code, body)
+	}
+	if len(annotations) != 1 || annotations[0].Message != "TestUnit failed" {
+		t.Errorf("unexpected annotations %+v", annotations)
+	}
+	if code, _ := get(t, h, "/api/bogus"); code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown path, got %d", http.StatusNotFound, code)
+	}
+
+	code, body = get(t, h, "/healthz")
+	var status health
+	if err := json.Unmarshal([]byte(body), &status); err != nil || code != http.StatusOK {
+		t.Fatalf("expected the health status, got %d: %s", code, body)
+	}
+	if status.LastRefresh == nil || !status.FetchedAt.Equal(fetchedAt) || status.LastError != "" {
+		t.Errorf("unexpected health status %+v", status)
+	}
+
+	// the data is kept when a refresh fails
+	srv.refresh(context.Background(), func(context.Context) (*snapshot, error) {
+		return nil, errors.New("rate limit exceeded")
+	})
+	if code, _ := get(t, h, "/"); code != http.StatusOK {
+		t.Errorf("expected the report to be served after a failed refresh, got %d", code)
+	}
+	_, body = get(t, h, "/healthz")
+	if err := json.Unmarshal([]byte(body), &status); err != nil || status.LastError != "rate limit exceeded" {
+		t.Errorf("expected the refresh error to be reported, got %s", body)
+	}
+}
+
+func TestServerCurrentReport(t *testing.T) {
+	srv := newServer(reportOptions{})
+	if r := srv.currentReport(); r != nil {
+		t.Fatalf("expected no report before any data is loaded, got %+v", r)
+	}
+
+	first := &snapshot{Jobs: []JobRun{{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", Conclusion: "success"}}}
+	srv.update(first)
+	r := srv.currentReport()
+	if r == nil || r.snapshot != first {
+		t.Fatalf("expected the report of the data served, got %+v", r)
+	}
+	if again := srv.currentReport(); again != r {
+		t.Errorf("expected the report to be built once per snapshot")
+	}
+
+	second := &snapshot{}
+	srv.update(second)
+	if r := srv.currentReport(); r == nil || r.snapshot != second {
+		t.Errorf("expected the report of the new data, got %+v", r)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	src := fileSource(path)
+	if _, err := src(context.Background()); err == nil {
+		t.Error("expected an error for a missing snapshot")
+	}
+
+	if err := writeSnapshot(path, &snapshot{Jobs: []JobRun{{Job: "unit"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := src(context.Background()); err != nil || s == nil || len(s.Jobs) != 1 {
+		t.Fatalf("expected the snapshot to be read, got %+v (%v)", s, err)
+	}
+	if s, err := src(context.Background()); err != nil || s != nil {
+		t.Fatalf("expected no new data while the file is unchanged, got %+v (%v)", s, err)
+	}
+
+	if err := writeSnapshot(path, &snapshot{Jobs: []JobRun{{Job: "unit"}, {Job: "lint"}}}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := src(context.Background()); err != nil || s == nil || len(s.Jobs) != 2 {
+		t.Fatalf("expected the updated snapshot to be read, got %+v (%v)", s, err)
+	}
+}
+
+func TestServerRefreshFromGithub(t *testing.T) {
+	server := fakegithub.New()
+	defer server.Close()
+	server.SetRateLimit(1000000, 1000000, time.Now().Add(time.Minute))
+	addWorkflowRuns(server, 2)
+
+	token, hadToken := os.LookupEnv(tokenLabel)
+	os.Setenv(tokenLabel, "token")
+	defer func() {
+		if hadToken {
+			os.Setenv(tokenLabel, token)
+		} else {
+			os.Unsetenv(tokenLabel)
+		}
+	}()
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.json")
+	configJSON := `{"repos": [{"owner": "linkerd", "repo": "linkerd2", "workflows": [{"file": "ci.yml", "name": "CI", "fetchAnnotations": true}]}]}`
+	if err := ioutil.WriteFile(config, []byte(configJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ff := &fetchFlags{
+		config:          config,
+		apiURL:          server.URL,
+		since:           "7d",
+		concurrency:     defaultConcurrency,
+		requestsPerHour: 1000000,
+		maxAttempts:     1,
+		noCache:         true,
+	}
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+
+	srv := newServer(reportOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f, cache, err := ff.newFetcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.refreshLoop(ctx, fetchSource(ff, f, cache, snapshotPath), 0, time.Hour)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.data() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the first refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(srv.data().Jobs); n != 4 {
+		t.Errorf("expected 4 jobs, got %d", n)
+	}
+	// the refreshes use the fetcher given, for its window to be the one of
+	// the flags
+	if since := time.Since(f.window.Since); since < 7*24*time.Hour-time.Minute || since > 7*24*time.Hour+time.Minute {
+		t.Errorf("expected the fetcher's window to start 7 days ago, got %s", f.window.Since)
+	}
+	s, err := readSnapshot(snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Jobs) != 4 {
+		t.Errorf("expected the refreshed data to be saved, got %d jobs", len(s.Jobs))
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(reportOptions{})
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.serve(ctx, l)
+	}()
+
+	resp, err := http.Get("http://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the shutdown")
+	}
+}
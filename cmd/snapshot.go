@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotVersion is the version of the snapshot format written by this
+// version of the tool. It must be bumped, and a migration added to
+// readSnapshot, whenever the format changes in an incompatible way. Version 0
+// is the first snapshot format, which had no Version, APIURL nor Repos fields.
+// Version 1 didn't record the test names of the annotations.
+const snapshotVersion = 2
+
+const (
+	// legacyJobsFile and legacyAnnotationsFile are the files of the format
+	// that predates snapshots, where jobs and annotations were saved as JSON
+	// arrays in two separate files of the same directory
+	legacyJobsFile        = "jobs.json"
+	legacyAnnotationsFile = "annotations.json"
+
+	// legacyRepo is the repo of the jobs saved in the legacy format, which
+	// only covered linkerd2 and didn't record it
+	legacyRepo = "linkerd/linkerd2"
+)
+
+// snapshot holds the data fetched from Github for a reporting window, so that
+// reports can be rendered from it later on without accessing the network
+type snapshot struct {
+	Version int
+
+	// FetchedAt and APIURL tell when and from where the data was fetched
+	FetchedAt time.Time
+	APIURL    string `json:",omitempty"`
+
+	// Repos lists the repos covered, in the owner/repo form
+	Repos       []string
+	Window      window
+	Jobs        []JobRun
+	Annotations []ErrorAnn
+}
+
+// readSnapshot loads the snapshot saved at path, migrating it to the current
+// version of the format if needed. If path is a directory, it's read as a
+// snapshot saved in the legacy format.
+func readSnapshot(path string) (*snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return readLegacySnapshot(path)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if s.Version > snapshotVersion {
+		return nil, fmt.Errorf("%s: snapshot format version %d is newer than the latest one supported (%d), upgrade this tool",
+			path, s.Version, snapshotVersion)
+	}
+	if s.Version < 0 {
+		return nil, fmt.Errorf("%s: invalid snapshot format version %d", path, s.Version)
+	}
+
+	// migrations, from the oldest version to the newest
+	if s.Version == 0 {
+		s.Repos = getRepos(s.Jobs)
+		s.Version = 1
+	}
+	if s.Version == 1 {
+		setTestNames(s.Annotations)
+		s.Version = 2
+	}
+	return &s, nil
+}
+
+// readLegacySnapshot loads the jobs and annotations saved under dir in the
+// legacy format. As that format didn't record it, the window is taken from
+// the jobs' start and completion times.
+func readLegacySnapshot(dir string) (*snapshot, error) {
+	s := &snapshot{Version: snapshotVersion}
+	if err := readJSONFile(filepath.Join(dir, legacyJobsFile), &s.Jobs); err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(filepath.Join(dir, legacyAnnotationsFile), &s.Annotations); err != nil {
+		return nil, err
+	}
+
+	for i := range s.Jobs {
+		if s.Jobs[i].Repo == "" {
+			s.Jobs[i].Repo = legacyRepo
+		}
+		started, completed := s.Jobs[i].Started.Time, s.Jobs[i].Completed.Time
+		if s.Window.Since.IsZero() || started.Before(s.Window.Since) {
+			s.Window.Since = started
+		}
+		if completed.After(s.Window.Until) {
+			s.Window.Until = completed
+		}
+		if started.After(s.Window.Until) {
+			s.Window.Until = started
+		}
+	}
+	for i := range s.Annotations {
+		if s.Annotations[i].Repo == "" {
+			s.Annotations[i].Repo = legacyRepo
+		}
+	}
+	setTestNames(s.Annotations)
+	s.Repos = getRepos(s.Jobs)
+	if info, err := os.Stat(filepath.Join(dir, legacyJobsFile)); err == nil {
+		s.FetchedAt = info.ModTime()
+	}
+	return s, nil
+}
+
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// writeSnapshot saves s at path, in the current version of the format. The
+// file is replaced at once, so that readers never see a partially written
+// snapshot.
+func writeSnapshot(path string, s *snapshot) error {
+	s.Version = snapshotVersion
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomically replaces the file at path with the content written by
+// write, through a temporary file renamed once complete
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	started := github.Timestamp{Time: time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)}
+	job := JobRun{Repo: "linkerd/linkerd2", Workflow: "CI", Job: "unit", CheckRunID: 1, Conclusion: "failure", Started: started, Completed: started}
+	s := &snapshot{
+		FetchedAt:   time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC),
+		APIURL:      defaultAPIURL,
+		Repos:       []string{"linkerd/linkerd2"},
+		Window:      window{Since: time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC), Until: time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)},
+		Jobs:        []JobRun{job},
+		Annotations: []ErrorAnn{{JobRun: job, Message: "TestInstall timed-out"}},
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := writeSnapshot(path, s); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Version != snapshotVersion || !reflect.DeepEqual(read, s) {
+		t.Errorf("expected %+v, got %+v", s, read)
+	}
+}
+
+func TestReadSnapshotVersions(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unversioned", `{"Jobs": [{"Repo": "linkerd/linkerd2", "Job": "unit"}]}`, ""},
+		{"without test names", `{"Version": 1, "Repos": ["linkerd/linkerd2"], "Annotations": [{"Message": "TestInject - CheckPods timed-out"}]}`, ""},
+		{"newer", `{"Version": 1000}`, "newer than the latest one supported"},
+		{"invalid", `{"Version": -1}`, "invalid snapshot format version"},
+		{"corrupted", `{"Version": 1`, "unexpected end of JSON input"},
+	}
+	for _, tc := range testCases {
+		path := filepath.Join(dir, tc.name+".json")
+		if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := readSnapshot(path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+			continue
+		}
+		if s.Version != snapshotVersion || !reflect.DeepEqual(s.Repos, []string{"linkerd/linkerd2"}) {
+			t.Errorf("%s: expected the snapshot to be migrated, got %+v", tc.name, s)
+		}
+		for _, ann := range s.Annotations {
+			if ann.Test != "TestInject" || ann.Detail != "CheckPods timed-out" {
+				t.Errorf("%s: expected the test name to be parsed, got %+v", tc.name, ann)
+			}
+		}
+	}
+}
+
+func TestReadLegacySnapshot(t *testing.T) {
+	s, err := readSnapshot("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Jobs) == 0 || len(s.Annotations) == 0 {
+		t.Fatalf("expected jobs and annotations, got %d and %d", len(s.Jobs), len(s.Annotations))
+	}
+	if !reflect.DeepEqual(s.Repos, []string{legacyRepo}) || s.Annotations[0].Repo != legacyRepo {
+		t.Errorf("expected the data to be assigned to %s, got repos %v", legacyRepo, s.Repos)
+	}
+	if ann := s.Annotations[0]; ann.Test != "TestEvents" || ann.Detail != "Error checking events" {
+		t.Errorf("expected the test name to be parsed, got %+v", ann)
+	}
+	for _, job := range s.Jobs {
+		if !s.Window.contains(job.Started.Time) {
+			t.Fatalf("job %+v out of the window %+v", job, s.Window)
+		}
+	}
+
+	// the legacy directory needs both files
+	if _, err := readSnapshot(t.TempDir()); err == nil {
+		t.Error("expected an error reading an empty directory")
+	}
+}
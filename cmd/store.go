@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	storeJobsFile  = "jobs.jsonl"
+	storeStateFile = "state.json"
+)
+
+// storeRecord is a line of the jobs file, holding a job along with its
+// annotations, so that both are persisted at once
+type storeRecord struct {
+	Job         JobRun
+	Annotations []ErrorAnn `json:",omitempty"`
+}
+
+// store persists jobs and annotations under a local directory as a JSON lines
+// file, keyed by check run ID, so that history accumulates across runs and
+// only the data that hasn't been seen before needs to be fetched. For each
+// workflow it also keeps a watermark: the creation time of the oldest workflow
+// run that needs to be looked at again in the next fetch, along with the start
+// of the oldest window fetched, since which all its runs are stored.
+type store struct {
+	dir string
+
+	mu          sync.Mutex
+	jobs        map[int64]JobRun
+	jobIDs      []int64
+	annotations map[int64][]ErrorAnn
+	state       storeState
+}
+
+// storeState is the content of the state file, keyed by workflow
+type storeState struct {
+	Watermarks map[string]time.Time
+	Since      map[string]time.Time
+}
+
+// openStore loads the store found under dir, creating dir if it doesn't exist
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &store{
+		dir:         dir,
+		jobs:        make(map[int64]JobRun),
+		annotations: make(map[int64][]ErrorAnn),
+	}
+
+	err := readJSONLines(filepath.Join(dir, storeJobsFile), func(data []byte) error {
+		var record storeRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		id := record.Job.CheckRunID
+		if _, ok := s.jobs[id]; !ok {
+			s.jobIDs = append(s.jobIDs, id)
+		}
+		s.jobs[id] = record.Job
+		s.annotations[id] = record.Annotations
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := ioutil.ReadFile(filepath.Join(dir, storeStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(state) > 0 {
+		if err := json.Unmarshal(state, &s.state); err != nil {
+			return nil, fmt.Errorf("%s: %s", filepath.Join(dir, storeStateFile), err)
+		}
+	}
+	if s.state.Watermarks == nil {
+		s.state.Watermarks = make(map[string]time.Time)
+	}
+	if s.state.Since == nil {
+		s.state.Since = make(map[string]time.Time)
+	}
+	return s, nil
+}
+
+// readJSONLines calls fn for every line of the file at path. A missing file
+// is treated as an empty one. A truncated last line, as left by an
+// interrupted write, is ignored.
+func readJSONLines(path string, fn func([]byte) error) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if fnErr := fn(line); fnErr != nil {
+				if err != nil {
+					log.Printf("%s:%d: ignoring truncated line", path, lineNum)
+					return nil
+				}
+				return fmt.Errorf("%s:%d: %s", path, lineNum, fnErr)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// appendJSONLines appends every item as a JSON line to the file at path
+func appendJSONLines(path string, n int, item func(int) interface{}) error {
+	if n == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		if err := enc.Encode(item(i)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// hasJob tells whether the job for checkRunID is already stored
+func (s *store) hasJob(checkRunID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.jobs[checkRunID]
+	return ok
+}
+
+// watermark returns the time from which the runs of the given workflow need
+// to be fetched, for a window starting at since. It's not found if the runs
+// since then aren't all stored, as when a window starting earlier than the
+// previous ones is asked for.
+func (s *store) watermark(repo repoConfig, workflow workflowConfig, since time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := watermarkKey(repo, workflow)
+	covered, ok := s.state.Since[key]
+	if !ok || since.Before(covered) {
+		return time.Time{}, false
+	}
+	t, ok := s.state.Watermarks[key]
+	return t, ok
+}
+
+// add persists the jobs of a workflow that weren't stored yet along with
+// their annotations, and the workflow's new watermark. since is the start of
+// the window the runs were fetched from, all its runs now being stored. The
+// state is left as is if watermark is zero.
+func (s *store) add(repo repoConfig, workflow workflowConfig, since, watermark time.Time, jobs []JobRun, annotations []ErrorAnn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobAnnotations := make(map[int64][]ErrorAnn)
+	for _, ann := range annotations {
+		jobAnnotations[ann.CheckRunID] = append(jobAnnotations[ann.CheckRunID], ann)
+	}
+	var records []storeRecord
+	for _, job := range jobs {
+		if _, ok := s.jobs[job.CheckRunID]; ok {
+			continue
+		}
+		records = append(records, storeRecord{job, jobAnnotations[job.CheckRunID]})
+	}
+	err := appendJSONLines(filepath.Join(s.dir, storeJobsFile), len(records), func(i int) interface{} {
+		return records[i]
+	})
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		id := record.Job.CheckRunID
+		s.jobs[id] = record.Job
+		s.jobIDs = append(s.jobIDs, id)
+		s.annotations[id] = record.Annotations
+	}
+
+	if watermark.IsZero() {
+		return nil
+	}
+	key := watermarkKey(repo, workflow)
+	s.state.Watermarks[key] = watermark
+	if covered, ok := s.state.Since[key]; !ok || since.Before(covered) {
+		s.state.Since[key] = since
+	}
+	state, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, storeStateFile+".tmp")
+	if err := ioutil.WriteFile(tmp, state, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, storeStateFile))
+}
+
+// workflowData returns the stored jobs and annotations for the given
+// workflow that started within w, most recent first
+func (s *store) workflowData(repo repoConfig, workflow workflowConfig, w window) ([]JobRun, []ErrorAnn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var jobs []JobRun
+	for _, id := range s.jobIDs {
+		job := s.jobs[id]
+		if job.Repo != repo.fullName() || job.Workflow != workflow.Name || !w.contains(job.Started.Time) {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if !jobs[i].Started.Time.Equal(jobs[j].Started.Time) {
+			return jobs[i].Started.After(jobs[j].Started.Time)
+		}
+		return jobs[i].CheckRunID > jobs[j].CheckRunID
+	})
+
+	var annotations []ErrorAnn
+	for _, job := range jobs {
+		annotations = append(annotations, s.annotations[job.CheckRunID]...)
+	}
+	return jobs, annotations
+}
+
+func watermarkKey(repo repoConfig, workflow workflowConfig) string {
+	return repo.fullName() + "/" + workflow.File
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreIncrementalFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ci-metrics-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, f := setupFakeGithub(t)
+	now := time.Now()
+	addWorkflowRun(server, 1, now.Add(-2*time.Hour))
+	addWorkflowRun(server, 2, now.Add(-time.Hour))
+
+	if f.store, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	jobs, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 4 || len(annotations) != 2 {
+		t.Fatalf("expected 4 jobs and 2 annotations, got %d and %d", len(jobs), len(annotations))
+	}
+	// 1 page of workflow runs + 2 check suites + 4 jobs' annotations
+	if requests := server.Requests(); requests != 7 {
+		t.Errorf("expected 7 requests in the first fetch, got %d", requests)
+	}
+
+	// reopen the store, to check the data is read back from disk, as a later
+	// run of the tool would
+	addWorkflowRun(server, 3, now)
+	f.window = defaultWindow(time.Now())
+	if f.store, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	jobs, annotations, err = f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 6 || len(annotations) != 3 {
+		t.Fatalf("expected 6 jobs and 3 annotations, got %d and %d", len(jobs), len(annotations))
+	}
+	if jobs[0].CheckRunID != 31 || annotations[0].Message != "TestInstall - run 3 timed-out" {
+		t.Errorf("expected the most recent run first, got job %+v and annotation %+v", jobs[0], annotations[0])
+	}
+	// 1 page of workflow runs + the 2 check suites of the runs updated since
+	// the watermark + the new jobs' annotations
+	if requests := server.Requests() - 7; requests != 5 {
+		t.Errorf("expected 5 requests in the second fetch, got %d", requests)
+	}
+}
+
+func TestStoreTruncatedLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ci-metrics-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := `{"Job":{"Repo":"linkerd/linkerd2","Workflow":"CI","Job":"test","CheckRunID":1}}
+{"Job":{"Repo":"linkerd/linkerd2","Workf`
+	if err := ioutil.WriteFile(filepath.Join(dir, storeJobsFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.hasJob(1) || len(s.jobs) != 1 {
+		t.Errorf("expected only the job with check run ID 1 to be loaded, got %+v", s.jobs)
+	}
+}
+
+// TestStoreWiderWindow checks that the runs older than the windows fetched
+// into the store are fetched once a wider window is asked for
+func TestStoreWiderWindow(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	now := time.Now()
+	addWorkflowRun(server, 1, now.Add(-60*24*time.Hour))
+	addWorkflowRun(server, 2, now.Add(-time.Hour))
+
+	var err error
+	if f.store, err = openStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	f.window = window{Since: now.Add(-30 * 24 * time.Hour), Until: now}
+	jobs, _, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("expected 2 jobs in the first fetch, got %d", len(jobs))
+	}
+
+	f.window = window{Since: now.Add(-90 * 24 * time.Hour), Until: now}
+	jobs, annotations, err := f.getData(context.Background(), testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 4 || len(annotations) != 2 {
+		t.Fatalf("expected 4 jobs and 2 annotations once the window is widened, got %d and %d", len(jobs), len(annotations))
+	}
+	if jobs[3].CheckRunID != 10 {
+		t.Errorf("expected the oldest run last, got %+v", jobs[3])
+	}
+
+	// the store now covers the wider window, so a narrower one is fetched
+	// from the watermark again
+	requests := server.Requests()
+	f.window = window{Since: now.Add(-60 * 24 * time.Hour), Until: now}
+	if _, _, err := f.getData(context.Background(), testConfig); err != nil {
+		t.Fatal(err)
+	}
+	// 1 page of workflow runs + the check suite of the run at the watermark
+	if n := server.Requests() - requests; n != 2 {
+		t.Errorf("expected 2 requests in the third fetch, got %d", n)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
+)
+
+// maxTestFailures is the number of tests shown in the failure leaderboard
+const maxTestFailures = 20
+
+// testMessage matches the annotation messages reporting the failure of a Go
+// test, as in "TestInject/proxy-version - CheckPods timed-out", or as printed
+// by go test in "--- FAIL: TestInject/proxy-version (1.23s)". Subtest names
+// can't hold spaces, which go test replaces with underscores.
+var testMessage = regexp.MustCompile(`(?s)^\s*(?:--- FAIL: )?(Test[A-Z0-9_]\w*(?:/[^\s:]+)*)(?:\s+\([\d.]+s\))?(?:(?:\s*[-:]\s*|\s+)(.*))?$`)
+
+// TestFailures holds how many jobs a Go test failed in, across all the
+// workflows of a repo, and the number of failures in each one of the jobs,
+// named as "workflow: job"
+type TestFailures struct {
+	Repo     string
+	Test     string
+	Failures int
+	Jobs     pairlist.PairList
+}
+
+// parseTestMessage returns the name of the Go test, with its subtests, whose
+// failure is reported by message, and the detail of the failure. If message
+// doesn't name a test, test is empty and detail is the whole message.
+func parseTestMessage(message string) (test, detail string) {
+	m := testMessage.FindStringSubmatch(message)
+	if m == nil {
+		return "", strings.TrimSpace(message)
+	}
+	return m[1], strings.TrimSpace(m[2])
+}
+
+// setTestNames fills the test name and detail of the annotations that don't
+// have them yet, as fetched by older versions
+func setTestNames(annotations []ErrorAnn) {
+	for i := range annotations {
+		if annotations[i].Test == "" && annotations[i].Detail == "" {
+			annotations[i].Test, annotations[i].Detail = parseTestMessage(annotations[i].Message)
+		}
+	}
+}
+
+// getTestFailures returns the Go tests that failed, from more to less
+// failures. Failures count the job runs a test failed in, however many
+// annotations it left in each one. Job runs are told apart by their start
+// time too, as data fetched by older versions doesn't record check run IDs.
+func getTestFailures(annotations []ErrorAnn) []TestFailures {
+	type testKey struct{ repo, test string }
+	type testRun struct {
+		testKey
+		workflow, job string
+		checkRunID    int64
+		started       time.Time
+	}
+	jobs := make(map[testKey]map[string]int)
+	seen := make(map[testRun]bool)
+	for _, ann := range annotations {
+		if ann.Test == "" {
+			continue
+		}
+		k := testKey{ann.Repo, ann.Test}
+		run := testRun{k, ann.Workflow, ann.Job, ann.CheckRunID, ann.Started.Time}
+		if seen[run] {
+			continue
+		}
+		seen[run] = true
+
+		if jobs[k] == nil {
+			jobs[k] = make(map[string]int)
+		}
+		jobs[k][ann.Workflow+": "+ann.Job]++
+	}
+
+	failures := make([]TestFailures, 0, len(jobs))
+	for k, counts := range jobs {
+		f := TestFailures{Repo: k.repo, Test: k.test, Jobs: rankByCount(counts)}
+		for _, n := range counts {
+			f.Failures += n
+		}
+		failures = append(failures, f)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Failures != failures[j].Failures {
+			return failures[i].Failures > failures[j].Failures
+		}
+		if failures[i].Repo != failures[j].Repo {
+			return failures[i].Repo < failures[j].Repo
+		}
+		return failures[i].Test < failures[j].Test
+	})
+	return failures
+}
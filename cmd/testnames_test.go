@@ -0,0 +1,74 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/linkerd/linkerd2-ci-metrics/cmd/pairlist"
+)
+
+func TestParseTestMessage(t *testing.T) {
+	testCases := []struct {
+		message string
+		test    string
+		detail  string
+	}{
+		{"TestInject - CheckPods timed-out", "TestInject", "CheckPods timed-out"},
+		{"TestInject/proxy-version - CheckPods timed-out", "TestInject/proxy-version", "CheckPods timed-out"},
+		{"TestInject/proxy-version/v2.10: unexpected output\nexpected 3 pods", "TestInject/proxy-version/v2.10", "unexpected output\nexpected 3 pods"},
+		{"--- FAIL: TestUpgradeCli/check (12.34s)", "TestUpgradeCli/check", ""},
+		{"Test_helm timed-out", "Test_helm", "timed-out"},
+		{"TestEgress", "TestEgress", ""},
+		{"Testing the install failed", "", "Testing the install failed"},
+		{"Unexpected HTTP response: 403", "", "Unexpected HTTP response: 403"},
+	}
+	for _, tc := range testCases {
+		test, detail := parseTestMessage(tc.message)
+		if test != tc.test || detail != tc.detail {
+			t.Errorf("parsing %q: expected %q and %q, got %q and %q", tc.message, tc.test, tc.detail, test, detail)
+		}
+	}
+}
+
+func TestGetTestFailures(t *testing.T) {
+	ann := func(checkRunID int64, workflow, job, message string) ErrorAnn {
+		a := ErrorAnn{
+			JobRun:  JobRun{Repo: "linkerd/linkerd2", Workflow: workflow, Job: job, CheckRunID: checkRunID},
+			Message: message,
+		}
+		a.Test, a.Detail = parseTestMessage(message)
+		return a
+	}
+	annotations := []ErrorAnn{
+		ann(1, "KinD integration", "stable", "TestInstall - CheckPods timed-out"),
+		// a second annotation of the same test in the same job run
+		ann(1, "KinD integration", "stable", "TestInstall - timed-out checking logs"),
+		ann(2, "KinD integration", "edge", "TestInstall - CheckPods timed-out"),
+		ann(3, "Cloud integration", "gke", "TestInstall - CheckPods timed-out"),
+		ann(4, "KinD integration", "stable", "TestInstall - CheckPods timed-out"),
+		ann(4, "KinD integration", "stable", "TestUpgrade/stable - unexpected version"),
+		ann(5, "KinD integration", "stable", "Unexpected HTTP response: 403"),
+	}
+
+	expected := []TestFailures{
+		{
+			Repo:     "linkerd/linkerd2",
+			Test:     "TestInstall",
+			Failures: 4,
+			Jobs: pairlist.PairList{
+				{Key: "KinD integration: stable", Value: 2},
+				{Key: "Cloud integration: gke", Value: 1},
+				{Key: "KinD integration: edge", Value: 1},
+			},
+		},
+		{
+			Repo:     "linkerd/linkerd2",
+			Test:     "TestUpgrade/stable",
+			Failures: 1,
+			Jobs:     pairlist.PairList{{Key: "KinD integration: stable", Value: 1}},
+		},
+	}
+	if failures := getTestFailures(annotations); !reflect.DeepEqual(failures, expected) {
+		t.Errorf("expected test failures:\n%+v\ngot:\n%+v", expected, failures)
+	}
+}
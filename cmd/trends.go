@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// bucket sizes of the success rate trends
+const (
+	bucketDay  = "day"
+	bucketWeek = "week"
+)
+
+// maxDailyWindow is the longest window whose trends are bucketed by day when
+// no bucket size is given; longer ones are bucketed by week
+const maxDailyWindow = 31 * 24 * time.Hour
+
+// Trend holds the success rate of a workflow, job or repo in each bucket of a
+// window. Rates are nil for the buckets without runs.
+type Trend struct {
+	Repo  string
+	Name  string
+	Rates []*int
+}
+
+// Trends holds the success rate trends over a window, bucketed by day or week
+type Trends struct {
+	// Buckets holds the start date of each bucket
+	Buckets   []string
+	Global    Trend
+	Repos     []Trend
+	Workflows []Trend
+	Jobs      []Trend
+}
+
+// bucketSize returns the bucket size to use for w when none is given
+func bucketSize(w window) string {
+	if w.Until.Sub(w.Since) <= maxDailyWindow {
+		return bucketDay
+	}
+	return bucketWeek
+}
+
+// validBucket returns an error if bucket isn't a valid bucket size. An empty
+// bucket is valid, meaning it's chosen according to the window.
+func validBucket(bucket string) error {
+	switch bucket {
+	case "", bucketDay, bucketWeek:
+		return nil
+	}
+	return fmt.Errorf("invalid bucket %q, expected %q or %q", bucket, bucketDay, bucketWeek)
+}
+
+// bucketStart returns the start of the bucket t falls in, in UTC. Weeks start
+// on Mondays.
+func bucketStart(t time.Time, bucket string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == bucketWeek {
+		// days since Monday
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return day
+}
+
+// getTrends buckets the jobs within w by day or week, and returns the success
+// rate in each bucket globally and for each repo, workflow and job. If qualify
+// is true, workflow and job names are prefixed with their repo.
+func getTrends(jobs []JobRun, w window, bucket string, qualify bool) Trends {
+	if bucket == "" {
+		bucket = bucketSize(w)
+	}
+	next := func(t time.Time) time.Time {
+		if bucket == bucketWeek {
+			return t.AddDate(0, 0, 7)
+		}
+		return t.AddDate(0, 0, 1)
+	}
+
+	var trends Trends
+	index := make(map[time.Time]int)
+	for t := bucketStart(w.Since, bucket); !t.After(w.Until); t = next(t) {
+		index[t] = len(trends.Buckets)
+		trends.Buckets = append(trends.Buckets, t.Format(dateFormat))
+	}
+
+	// runs and successes per bucket, for each trend
+	type counts struct {
+		runs      []int
+		successes []int
+	}
+	type trendKey struct{ repo, name string }
+	newCounts := func() *counts {
+		return &counts{make([]int, len(trends.Buckets)), make([]int, len(trends.Buckets))}
+	}
+	global := newCounts()
+	repos := make(map[trendKey]*counts)
+	workflows := make(map[trendKey]*counts)
+	jobCounts := make(map[trendKey]*counts)
+	add := func(m map[trendKey]*counts, key trendKey, i int, success bool) {
+		c, ok := m[key]
+		if !ok {
+			c = newCounts()
+			m[key] = c
+		}
+		c.runs[i]++
+		if success {
+			c.successes[i]++
+		}
+	}
+	for _, job := range jobs {
+		i, ok := index[bucketStart(job.Started.Time, bucket)]
+		if !ok {
+			continue
+		}
+		success := job.Conclusion == "success"
+		global.runs[i]++
+		if success {
+			global.successes[i]++
+		}
+		add(repos, trendKey{job.Repo, job.Repo}, i, success)
+		add(workflows, trendKey{job.Repo, qualifiedName(job.Repo, job.Workflow, qualify)}, i, success)
+		add(jobCounts, trendKey{job.Repo, qualifiedName(job.Repo, job.Job, qualify)}, i, success)
+	}
+
+	rates := func(c *counts) []*int {
+		r := make([]*int, len(c.runs))
+		for i := range c.runs {
+			if c.runs[i] > 0 {
+				rate := c.successes[i] * 100 / c.runs[i]
+				r[i] = &rate
+			}
+		}
+		return r
+	}
+	toTrends := func(m map[trendKey]*counts) []Trend {
+		list := make([]Trend, 0, len(m))
+		for key, c := range m {
+			list = append(list, Trend{Repo: key.repo, Name: key.name, Rates: rates(c)})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		return list
+	}
+	trends.Global = Trend{Name: "All", Rates: rates(global)}
+	trends.Repos = toTrends(repos)
+	trends.Workflows = toTrends(workflows)
+	trends.Jobs = toTrends(jobCounts)
+	return trends
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+func TestGetTrends(t *testing.T) {
+	// Wednesday
+	start := time.Date(2020, 6, 3, 12, 0, 0, 0, time.UTC)
+	job := func(repo, name, conclusion string, days int) JobRun {
+		return JobRun{
+			Repo:       repo,
+			Workflow:   "CI",
+			Job:        name,
+			Conclusion: conclusion,
+			Started:    github.Timestamp{Time: start.AddDate(0, 0, days)},
+		}
+	}
+	jobs := []JobRun{
+		job("linkerd/linkerd2", "unit", "success", 0),
+		job("linkerd/linkerd2", "unit", "failure", 0),
+		job("linkerd/linkerd2", "integration", "failure", 1),
+		job("linkerd/linkerd2", "unit", "success", 6),
+		job("linkerd/linkerd2-proxy", "unit", "success", 6),
+		// out of the window
+		job("linkerd/linkerd2", "unit", "failure", 30),
+	}
+	w := window{Since: start, Until: start.AddDate(0, 0, 7)}
+
+	daily := getTrends(jobs, w, "", true)
+	if len(daily.Buckets) != 8 || daily.Buckets[0] != "2020-06-03" {
+		t.Fatalf("unexpected daily buckets %v", daily.Buckets)
+	}
+	expectRates(t, "daily global", daily.Global, []int{50, 0, -1, -1, -1, -1, 100, -1})
+	if len(daily.Repos) != 2 || len(daily.Workflows) != 2 || len(daily.Jobs) != 3 {
+		t.Errorf("unexpected trends %+v", daily)
+	}
+	if daily.Jobs[0].Name != "linkerd/linkerd2-proxy: unit" || daily.Jobs[0].Repo != "linkerd/linkerd2-proxy" {
+		t.Errorf("expected qualified job names, got %+v", daily.Jobs[0])
+	}
+
+	weekly := getTrends(jobs, w, bucketWeek, false)
+	// the weeks starting on Mondays June 1st and 8th
+	if len(weekly.Buckets) != 2 || weekly.Buckets[0] != "2020-06-01" || weekly.Buckets[1] != "2020-06-08" {
+		t.Fatalf("unexpected weekly buckets %v", weekly.Buckets)
+	}
+	expectRates(t, "weekly global", weekly.Global, []int{33, 100})
+
+	if getTrends(jobs, window{Since: start, Until: start.AddDate(0, 2, 0)}, "", false).Buckets[0] != "2020-06-01" {
+		t.Error("expected windows longer than a month to be bucketed by week")
+	}
+}
+
+// expectRates checks the rates of trend, where -1 stands for no runs
+func expectRates(t *testing.T, name string, trend Trend, expected []int) {
+	t.Helper()
+	if len(trend.Rates) != len(expected) {
+		t.Fatalf("%s: expected %d rates, got %d", name, len(expected), len(trend.Rates))
+	}
+	for i, rate := range trend.Rates {
+		got := -1
+		if rate != nil {
+			got = *rate
+		}
+		if got != expected[i] {
+			t.Errorf("%s: expected rate %d in bucket %d, got %d", name, expected[i], i, got)
+		}
+	}
+}
@@ -4,6 +4,7 @@ const Index = `<html lang="en">
 <html>
   <head>
     <meta charset="UTF-8">
+    <title>{{ .Title }}</title>
     <script>{{ .ChartJS }}</script>
     <script>{{ .MainJS }}</script>
     <style>{{ .BootstrapCSS }}</style>
@@ -11,24 +12,78 @@ const Index = `<html lang="en">
     <script>
       const jobsSuccessRatesArr = {{ .JobSuccessRatesArr }};
       const workflowsArr = {{ .WorkflowsArr }};
-      window.onload = function() {
-        const jobsLabels = jobsSuccessRatesArr.map(j => j.Key)
-        const jobsDatasets = jobsSuccessRatesArr.map(j => j.Value)
-        jobsSuccessRates('jobs-success-rates', jobsLabels, jobsDatasets);
-        workflowsArr.forEach( workflow =>  {
+      const reposArr = {{ .ReposArr }};
+      const jobDurationsArr = {{ .JobDurationsArr }};
+      const trends = {{ .Trends }};
+      let jobsChart;
+      let durationsChart;
+      let trendCharts = [];
+      // renderCharts draws the charts for the given repo, or for all the
+      // repos if it's empty
+      const renderCharts = repo => {
+        let jobs = jobsSuccessRatesArr;
+        let workflows = workflowsArr;
+        if (repo) {
+          jobs = reposArr.find(r => r.Repo === repo).JobSuccessRates;
+          workflows = workflowsArr.filter(w => w.Repo === repo);
+        }
+        if (jobsChart) {
+          jobsChart.destroy();
+        }
+        const jobsLabels = jobs.map(j => j.Key)
+        const jobsDatasets = jobs.map(j => j.Value)
+        jobsChart = jobsSuccessRates('jobs-success-rates', jobsLabels, jobsDatasets);
+        if (durationsChart) {
+          durationsChart.destroy();
+        }
+        const durations = jobDurationsArr.filter(d => !repo || d.Repo === repo);
+        durationsChart = jobsDurations('jobs-durations', durations.map(d => d.Name),
+          durations.map(d => d.P50), durations.map(d => d.P90));
+        trendCharts.forEach(chart => chart.destroy());
+        const global = repo ? trends.Repos.filter(t => t.Repo === repo) : [trends.Global];
+        // the least successful jobs are shown first
+        const avg = t => {
+          const rates = t.Rates.filter(r => r !== null);
+          return rates.reduce((a, b) => a + b, 0) / rates.length;
+        };
+        const trendJobs = trends.Jobs.filter(t => !repo || t.Repo === repo).sort((a, b) => avg(a) - avg(b));
+        trendCharts = [
+          trendChart('global-trend', trends.Buckets, global, 1),
+          trendChart('workflows-trend', trends.Buckets, trends.Workflows.filter(t => !repo || t.Repo === repo), 10),
+          trendChart('jobs-trend', trends.Buckets, trendJobs, 5)
+        ];
+        document.getElementById('divWorkflowMessages').innerHTML = '';
+        workflows.forEach( workflow =>  {
           createCanvas(workflow.Id);
           const labels = workflow.Messages.map(m => m.Key);
-          const datasets = workflow.Messages.map(m => m.Value);
-          chart = workflowMessages(workflow, labels, datasets);
+          const datasets = workflow.Messages.map(m => m.Count);
+          const examples = workflow.Messages.map(m => m.Examples);
+          chart = workflowMessages(workflow, labels, datasets, examples);
 	  chart.canvas.parentNode.style.height = 80 + workflow.Messages.length*70;
         });
+        document.querySelectorAll('.flaky tr[data-repo], .durations tr[data-repo], .tests tr[data-repo], .hotspots tr[data-repo]').forEach(row => {
+          row.style.display = !repo || row.dataset.repo === repo ? '' : 'none';
+        });
+      };
+      window.onload = function() {
+        renderCharts('');
       };
     </script>
   </head>
   <body>
     <nav id="topNav" class="navbar navbar-dark bg-primary">
-      <div>Linkerd2 Integration Tests</div>
-      <div id="timespan">{{ .Start }} ⇨ {{ .End }}</div>
+      <div>{{ .Title }}</div>
+      <div id="timespan">
+        {{ if gt (len .RepoSuccessRates) 1 }}
+        <select id="repoSelector" onchange="renderCharts(this.value)">
+          <option value="">All repositories</option>
+          {{ range .RepoSuccessRates }}
+          <option value="{{ .Repo }}">{{ .Repo }}</option>
+          {{ end }}
+        </select>
+        {{ end }}
+        {{ .Start }} ⇨ {{ .End }}
+      </div>
     </nav>
     <div class="topWrapper">
       <div>
@@ -51,6 +106,31 @@ const Index = `<html lang="en">
             </div>
           </div>
         </div>
+        {{ if gt (len .RepoSuccessRates) 1 }}
+        <div id="ratesPerRepo" class="card shadow-lg p-3 mb-5 bg-white rounded">
+          <div class="card-body">
+            <h3>Success Rates per Repository</h3>
+            <div>
+              <table>
+                {{ range .RepoSuccessRates }}
+                <tr class="repo">
+                  <td class="left">{{ .Repo }}:</td>
+                  <td class="right">{{ .SuccessRate }}%</td>
+                  <td class="runs">{{ .Runs }} runs</td>
+                </tr>
+                {{ range .WorkflowSuccessRates }}
+                <tr class="workflow">
+                  <td class="left">{{ .Key }}:</td>
+                  <td class="right">{{ .Value }}%</td>
+                  <td></td>
+                </tr>
+                {{ end }}
+                {{ end }}
+              </table>
+            </div>
+          </div>
+        </div>
+        {{ end }}
       </div>
       <div class="card shadow-lg p-3 mb-5 bg-white rounded">
         <div class="card-body">
@@ -60,11 +140,214 @@ const Index = `<html lang="en">
       </div>
     </div>
 
+    <div id="trends" class="subSection shadow-lg p-3 mb-5 bg-white rounded">
+      <h3>Success Rate Trends</h3>
+      <div class="trendsWrapper">
+        <div>
+          <h4>Global</h4>
+          <canvas id="global-trend"></canvas>
+        </div>
+        <div>
+          <h4>Workflows</h4>
+          <canvas id="workflows-trend"></canvas>
+        </div>
+      </div>
+      <h4>Jobs</h4>
+      <p class="explanation">The five least successful jobs are shown; click on the legend to toggle the others.</p>
+      <canvas id="jobs-trend"></canvas>
+    </div>
+
+    <div id="durations" class="subSection shadow-lg p-3 mb-5 bg-white rounded">
+      <h3>Durations</h3>
+      <div class="durationsWrapper">
+        <div>
+          <h4>Jobs</h4>
+          <canvas id="jobs-durations"></canvas>
+        </div>
+        <div>
+          <h4>Workflows</h4>
+          <table class="durations">
+            <tr>
+              <th>Workflow</th>
+              <th class="count">Runs</th>
+              <th class="count">p50</th>
+              <th class="count">p90</th>
+              <th class="count">p99</th>
+              <th class="count">Mean</th>
+              <th class="count">Max</th>
+            </tr>
+            {{ range .WorkflowDurations }}
+            <tr data-repo="{{ .Repo }}">
+              <td>{{ .Name }}</td>
+              <td class="count">{{ .Runs }}</td>
+              <td class="count">{{ .P50 }}</td>
+              <td class="count">{{ .P90 }}</td>
+              <td class="count">{{ .P99 }}</td>
+              <td class="count">{{ .Mean }}</td>
+              <td class="count">{{ .Max }}</td>
+            </tr>
+            {{ end }}
+          </table>
+          {{ if .ComparedToPrevious }}
+          <h4>Slower than in the previous window</h4>
+          {{ if .Slowdowns }}
+          <table class="durations">
+            <tr>
+              <th>Job</th>
+              <th class="count">Previous p50</th>
+              <th class="count">p50</th>
+              <th class="count">Growth</th>
+            </tr>
+            {{ range .Slowdowns }}
+            <tr data-repo="{{ .Repo }}">
+              <td>{{ .Name }}</td>
+              <td class="count">{{ .Before }}</td>
+              <td class="count">{{ .After }}</td>
+              <td class="count">+{{ .Growth }}%</td>
+            </tr>
+            {{ end }}
+          </table>
+          {{ else }}
+          <p class="explanation">No job got significantly slower.</p>
+          {{ end }}
+          {{ end }}
+        </div>
+      </div>
+    </div>
+
     <div class="subSection shadow-lg p-3 mb-5 bg-white rounded">
       <h3>Failed Tests per Workflow</h3>
       <div id="divWorkflowMessages">
       </div>
     </div>
+
+    <div id="testFailures" class="subSection shadow-lg p-3 mb-5 bg-white rounded">
+      <h3>Most Failed Tests</h3>
+      <p class="explanation">
+        Go tests named in the error messages, across all the workflows, with the
+        number of job runs they failed in.
+      </p>
+      {{ if .TestFailures }}
+      <table class="tests">
+        <tr>
+          <th>Test</th>
+          <th class="count">Failures</th>
+          <th>Jobs</th>
+        </tr>
+        {{ range .TestFailures }}
+        <tr data-repo="{{ .Repo }}">
+          <td class="message">{{ .Test }}</td>
+          <td class="count">{{ .Failures }}</td>
+          <td>
+            {{ range .Jobs }}
+            <div>{{ .Key }} ({{ .Value }})</div>
+            {{ end }}
+          </td>
+        </tr>
+        {{ end }}
+      </table>
+      {{ else }}
+      <p class="explanation">No Go test was found in the error messages.</p>
+      {{ end }}
+    </div>
+
+    <div id="hotspots" class="subSection shadow-lg p-3 mb-5 bg-white rounded">
+      <h3>Failure Hotspots</h3>
+      <p class="explanation">
+        Files and lines the error messages point to, with the number of job runs
+        that failed there. Links point to the commit of the latest failure.
+      </p>
+      {{ if .HotspotFiles }}
+      <div class="hotspotsWrapper">
+        <div>
+          <h4>Files</h4>
+          <table class="hotspots">
+            <tr>
+              <th>File</th>
+              <th class="count">Failures</th>
+            </tr>
+            {{ range .HotspotFiles }}
+            <tr data-repo="{{ .Repo }}">
+              <td class="location">{{ if .URL }}<a href="{{ .URL }}">{{ .Location }}</a>{{ else }}{{ .Location }}{{ end }}</td>
+              <td class="count">{{ .Failures }}</td>
+            </tr>
+            {{ end }}
+          </table>
+        </div>
+        <div>
+          <h4>Lines</h4>
+          <table class="hotspots">
+            <tr>
+              <th>Lines</th>
+              <th class="count">Failures</th>
+              <th>Tests</th>
+            </tr>
+            {{ range .HotspotLines }}
+            <tr data-repo="{{ .Repo }}">
+              <td class="location">{{ if .URL }}<a href="{{ .URL }}">{{ .Location }}</a>{{ else }}{{ .Location }}{{ end }}</td>
+              <td class="count">{{ .Failures }}</td>
+              <td>
+                {{ range .Tests }}
+                <div>{{ .Key }} ({{ .Value }})</div>
+                {{ end }}
+              </td>
+            </tr>
+            {{ end }}
+          </table>
+        </div>
+      </div>
+      {{ else }}
+      <p class="explanation">No error message points to a file.</p>
+      {{ end }}
+    </div>
+
+    <div id="flakiest" class="subSection shadow-lg p-3 mb-5 bg-white rounded">
+      <h3>Flakiest Tests</h3>
+      <p class="explanation">
+        Tests and jobs that failed and then passed on the same commit, when re-run.
+        Flakiness is the share of the commits where they failed that ended up passing.
+      </p>
+      {{ if or .FlakyTests .FlakyJobs }}
+      {{ if .FlakyTests }}
+      <table class="flaky">
+        <tr>
+          <th>Test</th>
+          <th>Workflow</th>
+          <th class="count">Flaky failures</th>
+          <th class="count">Flakiness</th>
+        </tr>
+        {{ range .FlakyTests }}
+        <tr data-repo="{{ .Repo }}">
+          <td class="message">{{ .Name }}</td>
+          <td>{{ .Workflow }}</td>
+          <td class="count">{{ .Flaky }} of {{ .Failures }}</td>
+          <td class="count">{{ .Score }}%</td>
+        </tr>
+        {{ end }}
+      </table>
+      {{ end }}
+      {{ if .FlakyJobs }}
+      <table class="flaky">
+        <tr>
+          <th>Job</th>
+          <th>Workflow</th>
+          <th class="count">Flaky failures</th>
+          <th class="count">Flakiness</th>
+        </tr>
+        {{ range .FlakyJobs }}
+        <tr data-repo="{{ .Repo }}">
+          <td>{{ .Name }}</td>
+          <td>{{ .Workflow }}</td>
+          <td class="count">{{ .Flaky }} of {{ .Failures }}</td>
+          <td class="count">{{ .Score }}%</td>
+        </tr>
+        {{ end }}
+      </table>
+      {{ end }}
+      {{ else }}
+      <p class="explanation">No job failed and then passed on the same commit.</p>
+      {{ end }}
+    </div>
   </body>
 </html>`
 
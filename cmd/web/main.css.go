@@ -57,6 +57,45 @@ h3 {
   font-size: 40px;
 }
 
+#repoSelector {
+  font-size: 20px;
+  margin-right: 20px;
+  vertical-align: middle;
+}
+
+#ratesPerRepo {
+  width: 80%;
+  margin: 0 auto;
+}
+
+#ratesPerRepo .card-body div {
+  margin-top: 20px;
+  padding-left: 40px;
+}
+
+#ratesPerRepo table {
+  width: 100%;
+}
+
+#ratesPerRepo .repo .left {
+  font-size: 30px;
+}
+
+#ratesPerRepo .repo .right {
+  font-size: 35px;
+}
+
+#ratesPerRepo .workflow td {
+  font-size: 20px;
+  padding-left: 30px;
+  color: #555;
+}
+
+#ratesPerRepo .runs {
+  font-size: 20px;
+  color: #888;
+}
+
 .subSection {
   margin-top:50px;
 }
@@ -70,6 +109,135 @@ h3 {
 #divWorkflowMessages {
   display:grid;
   grid-template-columns:1fr 1fr 1fr;
+}
+
+.trendsWrapper {
+  display: grid;
+  grid-template-columns: 1fr 1fr;
+  grid-gap: 40px;
+  margin: 20px 0;
+}
+
+#trends h4 {
+  text-align: center;
+}
+
+#trends .explanation {
+  text-align: center;
+  color: #555;
+}
+
+.durationsWrapper {
+  display: grid;
+  grid-template-columns: 1fr 1fr;
+  grid-gap: 40px;
+  margin-top: 20px;
+}
+
+#durations h4 {
+  text-align: center;
+}
+
+#durations .explanation {
+  text-align: center;
+  color: #555;
+}
+
+#durations .durations {
+  width: 100%;
+  margin-bottom: 30px;
+}
+
+#durations .durations th, #durations .durations td {
+  padding: 5px 10px;
+  border-bottom: 1px solid #ddd;
+}
+
+#durations .durations .count {
+  text-align: right;
+  white-space: nowrap;
+}
+
+#flakiest .explanation {
+  text-align: center;
+  color: #555;
+}
+
+#flakiest .flaky {
+  width: 100%;
+  margin-top: 20px;
+}
+
+#flakiest .flaky th, #flakiest .flaky td {
+  padding: 5px 10px;
+  border-bottom: 1px solid #ddd;
+}
+
+#flakiest .flaky .message {
+  font-family: monospace;
+  white-space: pre-wrap;
+}
+
+#flakiest .flaky .count {
+  text-align: right;
+  white-space: nowrap;
+}
+
+#testFailures .explanation {
+  text-align: center;
+  color: #555;
+}
+
+#testFailures .tests {
+  width: 100%;
+  margin-top: 20px;
+}
+
+#testFailures .tests th, #testFailures .tests td {
+  padding: 5px 10px;
+  border-bottom: 1px solid #ddd;
+  vertical-align: top;
+}
+
+#testFailures .tests .message {
+  font-family: monospace;
+}
+
+#testFailures .tests .count {
+  text-align: right;
+  white-space: nowrap;
+}
+
+.hotspotsWrapper {
+  display: grid;
+  grid-template-columns: 1fr 1fr;
+  grid-gap: 40px;
+  margin-top: 20px;
+}
+
+#hotspots .explanation {
+  text-align: center;
+  color: #555;
+}
+
+#hotspots .hotspots {
+  width: 100%;
+}
+
+#hotspots .hotspots th, #hotspots .hotspots td {
+  padding: 5px 10px;
+  border-bottom: 1px solid #ddd;
+  vertical-align: top;
+}
+
+#hotspots .hotspots .location {
+  font-family: monospace;
+  word-break: break-all;
+}
+
+#hotspots .hotspots .count {
+  text-align: right;
+  white-space: nowrap;
 }`
 
 /* vim: set tabstop=4:softtabstop=4:shiftwidth=4:expandtab */
@@ -67,7 +67,102 @@ const jobsSuccessRates = (id, labels, datasets) => {
   });
 }
 
-const workflowMessages = (workflow, labels, datasets) => {
+// jobsDurations draws the median and 90th percentile durations of the jobs,
+// given in nanoseconds, in minutes
+const jobsDurations = (id, labels, p50s, p90s) => {
+  const minutes = ns => Math.round(ns / 6e8) / 100;
+  var ctx = document.getElementById(id).getContext('2d');
+  return new Chart(ctx, {
+    type: 'horizontalBar',
+    data: {
+      labels: labels,
+      datasets: [{
+        label: 'p50',
+        data: p50s.map(minutes),
+        backgroundColor: 'rgba(54, 162, 235, 0.8)',
+        barThickness: 'flex'
+      }, {
+        label: 'p90',
+        data: p90s.map(minutes),
+        backgroundColor: 'rgba(54, 162, 235, 0.3)',
+        barThickness: 'flex'
+      }]
+    },
+    options: {
+      title: {
+        display: false,
+      },
+      scales: {
+        xAxes: [{
+          ticks: {
+            beginAtZero: true
+          },
+          scaleLabel: {
+            display: true,
+            labelString: 'minutes'
+          },
+          gridLines: {
+            display: false
+          }
+        }]
+      },
+      legend: {
+        display: true
+      },
+      layout: {
+        padding: 0
+      }
+    }
+  });
+}
+
+// trendChart draws a line for each one of the trends, over the given bucket
+// labels. Only the first visible trends are shown at first; the others can be
+// toggled through the legend.
+const trendChart = (id, labels, trends, visible) => {
+  var ctx = document.getElementById(id).getContext('2d');
+  return new Chart(ctx, {
+    type: 'line',
+    data: {
+      labels: labels,
+      datasets: trends.map((trend, i) => {
+        const color = 'hsl(' + (i * 137.5) % 360 + ', 60%, 50%)';
+        return {
+          label: trend.Name,
+          data: trend.Rates,
+          borderColor: color,
+          backgroundColor: color,
+          fill: false,
+          spanGaps: true,
+          hidden: i >= visible
+        };
+      })
+    },
+    options: {
+      scales: {
+        yAxes: [{
+          ticks: {
+            beginAtZero: true,
+            max: 100
+          },
+          scaleLabel: {
+            display: true,
+            labelString: 'success rate (%)'
+          }
+        }]
+      },
+      legend: {
+        display: true,
+        position: 'bottom'
+      }
+    }
+  });
+}
+
+// workflowMessages draws the number of failures of each cluster of error
+// messages of the workflow. The tooltips show the raw messages given as
+// examples of each cluster.
+const workflowMessages = (workflow, labels, datasets, examples) => {
   var ctx = document.getElementById(workflow.Id).getContext('2d');
   return new Chart(ctx, {
     type: 'horizontalBar',
@@ -105,6 +200,16 @@ const workflowMessages = (workflow, labels, datasets) => {
       legend: {
         display: false
       },
+      tooltips: {
+        callbacks: {
+          footer: items => {
+            if (!items.length) {
+              return [];
+            }
+            return ['Examples:'].concat(examples[items[0].index].map(e => wrap(e, 80)).flat());
+          }
+        }
+      },
       layout: {
         padding: 0
       },
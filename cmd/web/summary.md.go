@@ -0,0 +1,51 @@
+package web
+
+const Summary = `## CI health summary
+
+{{ .Start }} to {{ .End }}: **{{ pct .Totals.SuccessRate }}** of the {{ .Totals.Runs }} job runs succeeded
+{{- with .Delta }} ({{ . }} points {{ $.Versus }}){{ end }}.
+
+### Workflows
+{{ if .Workflows }}
+| Workflow | Runs | Failures | Success rate |{{ if .Compared }} Change |{{ end }}
+|----------|-----:|---------:|-------------:|{{ if .Compared }}-------:|{{ end }}
+{{- range .Workflows }}
+| {{ md .Name }} | {{ .Runs }} | {{ .Failures }} | {{ pct .SuccessRate }} |{{ if $.Compared }} {{ .Delta }} |{{ end }}
+{{- end }}
+{{ else }}
+No job ran.
+{{ end }}
+### Top failing jobs
+{{ if .FailingJobs }}
+| Job | Workflow | Failures | Runs | Success rate |{{ if .Compared }} Change |{{ end }}
+|-----|----------|---------:|-----:|-------------:|{{ if .Compared }}-------:|{{ end }}
+{{- range .FailingJobs }}
+| {{ md .Job }} | {{ md .Name }} | {{ .Failures }} | {{ .Runs }} | {{ pct .SuccessRate }} |{{ if $.Compared }} {{ .Delta }} |{{ end }}
+{{- end }}
+{{ else }}
+No job failed.
+{{ end }}
+<details>
+<summary>All {{ len .Jobs }} jobs</summary>
+
+| Job | Workflow | Failures | Runs | Success rate |{{ if .Compared }} Change |{{ end }}
+|-----|----------|---------:|-----:|-------------:|{{ if .Compared }}-------:|{{ end }}
+{{- range .Jobs }}
+| {{ md .Job }} | {{ md .Name }} | {{ .Failures }} | {{ .Runs }} | {{ pct .SuccessRate }} |{{ if $.Compared }} {{ .Delta }} |{{ end }}
+{{- end }}
+
+</details>
+
+### Top error messages
+{{ if .Messages }}{{ range .Messages }}
+<details>
+<summary>{{ .Count }} × {{ html .Message }} ({{ html .Name }})</summary>
+{{ range .Examples }}
+{{ code . }}
+{{ end }}
+</details>
+{{ end }}
+{{- else }}
+No error message.
+{{ end -}}
+`
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+)
+
+const (
+	// webhookSecretLabel is the env var holding the secret the webhook
+	// deliveries are signed with
+	webhookSecretLabel = "GITHUB_WEBHOOK_SECRET"
+
+	// Github caps the webhook payloads at 25MB
+	maxWebhookPayload = 25 << 20
+	// runTTL is how long the workflow runs seen in the deliveries are
+	// remembered, for the check runs and jobs delivered after them
+	runTTL = 24 * time.Hour
+
+	// actionsApp is the slug of the Github app creating the check runs of
+	// the Github Actions jobs
+	actionsApp = "github-actions"
+
+	// webhookQueueSize is the number of deliveries waiting to be handled
+	// above which new ones are turned down
+	webhookQueueSize = 100
+)
+
+// the headers of the webhook deliveries, as set by Github
+const (
+	eventHeader        = "X-GitHub-Event"
+	signatureHeader    = "X-Hub-Signature"
+	signature256Header = "X-Hub-Signature-256"
+)
+
+// workflowRunEvent is the payload of the workflow_run deliveries, sent when a
+// workflow run is requested and completed
+type workflowRunEvent struct {
+	Action      string             `json:"action"`
+	WorkflowRun workflowRun        `json:"workflow_run"`
+	Repo        *github.Repository `json:"repository"`
+}
+
+// workflowJobEvent is the payload of the workflow_job deliveries, sent when a
+// job of a workflow run is queued, starts and completes
+type workflowJobEvent struct {
+	Action      string             `json:"action"`
+	WorkflowJob workflowJob        `json:"workflow_job"`
+	Repo        *github.Repository `json:"repository"`
+}
+
+// workflowJob is a job of a workflow run, whose ID is also the ID of its
+// check run
+type workflowJob struct {
+	ID          int64            `json:"id"`
+	RunID       int64            `json:"run_id"`
+	RunAttempt  int              `json:"run_attempt"`
+	Name        string           `json:"name"`
+	HeadSHA     string           `json:"head_sha"`
+	Conclusion  string           `json:"conclusion"`
+	StartedAt   github.Timestamp `json:"started_at"`
+	CompletedAt github.Timestamp `json:"completed_at"`
+}
+
+// delivery is a webhook delivery waiting to be handled
+type delivery struct {
+	id      string
+	event   string
+	payload []byte
+}
+
+// trackedRun is a run of one of the workflows in the config, as seen in the
+// webhook deliveries or fetched from Github
+type trackedRun struct {
+	repo     repoConfig
+	workflow workflowConfig
+	run      workflowRun
+	seen     time.Time
+}
+
+// webhook receives the workflow_run, workflow_job and check_run deliveries of
+// the Github webhook, so that the jobs of the repos and workflows in the config
+// are added to the data served as soon as they complete, along with their
+// annotations, instead of waiting for the next refresh. The jobs received are
+// also persisted to the store, if any, where the next fetches find them.
+//
+// A job is usually delivered twice, as a workflow job and as a check run, and
+// once more when its workflow run completes, as the jobs of the run are then
+// fetched in case some deliveries were missed; the duplicates are left out.
+// Check runs don't tell which workflow run they belong to, so they're only
+// recorded once the workflow run was seen in a workflow_run delivery.
+//
+// Github gives up on deliveries not answered within 10 seconds, which may not
+// be enough to fetch the jobs of a run and their annotations, let alone when
+// waiting for the rate limit to reset. Deliveries are thus answered as soon as
+// their signature is checked, and queued to be handled in order by run.
+type webhook struct {
+	secret  []byte
+	cfg     *config
+	fetcher *fetcher
+	server  *server
+
+	queue chan delivery
+	// pending counts the deliveries queued and not handled yet
+	pending sync.WaitGroup
+
+	mu sync.Mutex
+	// runs holds the runs of the workflows in the config by ID, and suites
+	// their IDs by check suite ID
+	runs   map[int64]*trackedRun
+	suites map[int64]int64
+}
+
+func newWebhook(secret []byte, cfg *config, f *fetcher, srv *server) *webhook {
+	return &webhook{
+		secret:  secret,
+		cfg:     cfg,
+		fetcher: f,
+		server:  srv,
+		queue:   make(chan delivery, webhookQueueSize),
+		runs:    make(map[int64]*trackedRun),
+		suites:  make(map[int64]int64),
+	}
+}
+
+// ServeHTTP checks the signature of a delivery against the secret, and queues
+// it to be handled by run. The deliveries of the events other than
+// workflow_run, workflow_job and check_run are ignored. When the queue is full,
+// deliveries are answered with a 503, so that they can be redelivered from the
+// Github UI.
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "the webhook content type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		http.Error(w, "failed to read the payload", http.StatusBadRequest)
+		return
+	}
+	// the SHA-256 signature is preferred, the SHA-1 one being kept by Github
+	// for compatibility only
+	signature := r.Header.Get(signature256Header)
+	if signature == "" {
+		signature = r.Header.Get(signatureHeader)
+	}
+	if err := github.ValidateSignature(signature, payload, h.secret); err != nil {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	d := delivery{id: github.DeliveryID(r), event: r.Header.Get(eventHeader), payload: payload}
+	switch d.event {
+	case "workflow_run", "workflow_job", "check_run":
+	default:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.pending.Add(1)
+	select {
+	case h.queue <- d:
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		h.pending.Done()
+		http.Error(w, "too many deliveries waiting to be handled", http.StatusServiceUnavailable)
+	}
+}
+
+// run handles the queued deliveries in turn until ctx is done, logging the
+// failures. The deliveries still queued then are dropped.
+func (h *webhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-h.queue:
+			if err := h.handle(ctx, d.event, d.payload); err != nil {
+				log.Printf("webhook: %s delivery %s: %s", d.event, d.id, err)
+			}
+			h.pending.Done()
+		}
+	}
+}
+
+// handle handles a delivery of the given event type
+func (h *webhook) handle(ctx context.Context, event string, payload []byte) error {
+	switch event {
+	case "workflow_run":
+		var e workflowRunEvent
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return err
+		}
+		return h.handleWorkflowRun(ctx, e)
+	case "workflow_job":
+		var e workflowJobEvent
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return err
+		}
+		return h.handleWorkflowJob(ctx, e)
+	case "check_run":
+		var e github.CheckRunEvent
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return err
+		}
+		return h.handleCheckRun(ctx, e)
+	}
+	return nil
+}
+
+// handleWorkflowRun remembers the run, and records its jobs once it completes
+func (h *webhook) handleWorkflowRun(ctx context.Context, e workflowRunEvent) error {
+	if e.WorkflowRun.WorkflowRun == nil {
+		return fmt.Errorf("no workflow run in the payload")
+	}
+	repo, workflow, ok := h.cfg.workflow(e.Repo.GetFullName(), path.Base(e.WorkflowRun.Path))
+	if !ok {
+		return nil
+	}
+	run := h.remember(repo, workflow, e.WorkflowRun)
+	if e.Action != completed || e.WorkflowRun.GetConclusion() == "cancelled" {
+		return nil
+	}
+
+	jobs, _, err := h.fetcher.getJobRuns(ctx, repo, run.run, run.run.CheckSuiteID, workflow, time.Time{})
+	if err != nil {
+		return err
+	}
+	return h.record(ctx, repo, workflow, jobs)
+}
+
+// handleWorkflowJob records the job once it completes
+func (h *webhook) handleWorkflowJob(ctx context.Context, e workflowJobEvent) error {
+	job := e.WorkflowJob
+	if e.Action != completed || job.Conclusion == "cancelled" {
+		return nil
+	}
+	run, err := h.lookup(ctx, e.Repo.GetFullName(), job.RunID)
+	if err != nil || run == nil {
+		return err
+	}
+	// older deliveries have no run attempt
+	attempt := job.RunAttempt
+	if attempt == 0 {
+		attempt = run.run.RunAttempt
+	}
+	return h.record(ctx, run.repo, run.workflow, []JobRun{{
+		Repo:       run.repo.fullName(),
+		Workflow:   run.workflow.Name,
+		Job:        job.Name,
+		CheckRunID: job.ID,
+		RunID:      job.RunID,
+		RunAttempt: attempt,
+		HeadSHA:    job.HeadSHA,
+		Conclusion: job.Conclusion,
+		Started:    job.StartedAt,
+		Completed:  job.CompletedAt,
+	}})
+}
+
+// handleCheckRun records the check run of a Github Actions job once it
+// completes, if the workflow run it belongs to is known
+func (h *webhook) handleCheckRun(ctx context.Context, e github.CheckRunEvent) error {
+	checkRun := e.GetCheckRun()
+	if e.GetAction() != completed || checkRun.GetConclusion() == "cancelled" || checkRun.GetApp().GetSlug() != actionsApp {
+		return nil
+	}
+	h.mu.Lock()
+	run := h.runs[h.suites[checkRun.GetCheckSuite().GetID()]]
+	h.mu.Unlock()
+	if run == nil {
+		return nil
+	}
+	return h.record(ctx, run.repo, run.workflow, []JobRun{{
+		Repo:       run.repo.fullName(),
+		Workflow:   run.workflow.Name,
+		Job:        checkRun.GetName(),
+		CheckRunID: checkRun.GetID(),
+		RunID:      run.run.GetID(),
+		RunAttempt: run.run.RunAttempt,
+		HeadSHA:    checkRun.GetHeadSHA(),
+		Conclusion: checkRun.GetConclusion(),
+		Started:    checkRun.GetStartedAt(),
+		Completed:  checkRun.GetCompletedAt(),
+	}})
+}
+
+// remember records the run of a workflow in the config, forgetting the runs
+// not seen for runTTL
+func (h *webhook) remember(repo repoConfig, workflow workflowConfig, run workflowRun) *trackedRun {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for id, r := range h.runs {
+		if now.Sub(r.seen) > runTTL {
+			delete(h.runs, id)
+			delete(h.suites, r.run.CheckSuiteID)
+		}
+	}
+	tracked := &trackedRun{repo: repo, workflow: workflow, run: run, seen: now}
+	h.runs[run.GetID()] = tracked
+	if run.CheckSuiteID != 0 {
+		h.suites[run.CheckSuiteID] = run.GetID()
+	}
+	return tracked
+}
+
+// lookup returns the run of repo with the given ID, fetching it from Github
+// if it wasn't seen yet. It returns nil if the run isn't one of a workflow in
+// the config.
+func (h *webhook) lookup(ctx context.Context, fullName string, runID int64) (*trackedRun, error) {
+	h.mu.Lock()
+	run := h.runs[runID]
+	h.mu.Unlock()
+	if run != nil {
+		return run, nil
+	}
+
+	repo, ok := h.cfg.repo(fullName)
+	if !ok {
+		return nil, nil
+	}
+	r, err := h.fetcher.getWorkflowRun(ctx, repo, runID)
+	if err != nil {
+		return nil, err
+	}
+	repo, workflow, ok := h.cfg.workflow(fullName, path.Base(r.Path))
+	if !ok {
+		return nil, nil
+	}
+	return h.remember(repo, workflow, *r), nil
+}
+
+// record fetches the annotations of the jobs not recorded yet if the
+// workflow's annotations are to be fetched, persists them to the store and
+// adds them to the data served
+func (h *webhook) record(ctx context.Context, repo repoConfig, workflow workflowConfig, jobs []JobRun) error {
+	var newJobs []JobRun
+	for _, job := range jobs {
+		if h.server.hasJob(job.CheckRunID) || (h.fetcher.store != nil && h.fetcher.store.hasJob(job.CheckRunID)) {
+			continue
+		}
+		newJobs = append(newJobs, job)
+	}
+	if len(newJobs) == 0 {
+		return nil
+	}
+
+	var annotations []ErrorAnn
+	if workflow.FetchAnnotations {
+		var err error
+		if annotations, err = h.fetcher.getJobsAnnotations(ctx, repo, newJobs); err != nil {
+			return err
+		}
+	}
+	if h.fetcher.store != nil {
+		if err := h.fetcher.store.add(repo, workflow, time.Time{}, time.Time{}, newJobs, annotations); err != nil {
+			return err
+		}
+	}
+	h.server.add(newJobs, annotations)
+	log.Printf("webhook: received %d jobs and %d annotations of workflow %q in %s",
+		len(newJobs), len(annotations), workflow.Name, repo.fullName())
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "It's a Secret to Everybody"
+
+// signature returns the signature of payload with the secret, as sent by
+// Github in the header named by prefix
+func signature(prefix string, payload []byte, secret string) string {
+	hash := sha256.New
+	if prefix == "sha1" {
+		hash = sha1.New
+	}
+	mac := hmac.New(hash, []byte(secret))
+	mac.Write(payload)
+	return prefix + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts payload to the /webhook endpoint of h as a delivery of event
+// signed with the secret, returning the response status
+func deliver(h http.Handler, event string, payload []byte, secret string) int {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(eventHeader, event)
+	r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	r.Header.Set(signatureHeader, signature("sha1", payload, secret))
+	r.Header.Set(signature256Header, signature("sha256", payload, secret))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec.Code
+}
+
+// TestWebhookReplay replays the deliveries recorded under testdata/webhooks,
+// whose file names give the order and the event type
+func TestWebhookReplay(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 2)
+	dir := t.TempDir()
+	var err error
+	if f.store, err = openStore(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newServer(reportOptions{})
+	since := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	srv.update(&snapshot{Window: window{Since: since, Until: since.Add(time.Hour)}})
+	hook := newWebhook([]byte(testSecret), testConfig, f, srv)
+	srv.webhook = hook
+	h := srv.handler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.run(ctx)
+
+	files, err := filepath.Glob("testdata/webhooks/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no recorded deliveries found (%v)", err)
+	}
+	replay := func() {
+		for _, file := range files {
+			payload, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := strings.TrimSuffix(filepath.Base(file), ".json")
+			event := name[strings.Index(name, "-")+1:]
+			// the ping isn't queued
+			expected := http.StatusAccepted
+			if event == "ping" {
+				expected = http.StatusNoContent
+			}
+			if code := deliver(h, event, payload, testSecret); code != expected {
+				t.Fatalf("%s: expected status %d, got %d", file, expected, code)
+			}
+		}
+		hook.pending.Wait()
+	}
+	replay()
+
+	// the integration tests job of run 101 is delivered as a check run, and
+	// the unit tests one is fetched once the run completes; the integration
+	// tests job of run 102 is delivered as a workflow job, its run being
+	// fetched. The Docs workflow isn't in the config.
+	s := srv.data()
+	expectedJobs := map[int64]string{10: "success", 11: "failure", 21: "failure"}
+	if len(s.Jobs) != len(expectedJobs) {
+		t.Fatalf("expected %d jobs, got %+v", len(expectedJobs), s.Jobs)
+	}
+	for _, job := range s.Jobs {
+		if expectedJobs[job.CheckRunID] != job.Conclusion || job.Workflow != "CI" || job.Repo != "linkerd/linkerd2" || job.RunAttempt != 1 {
+			t.Errorf("unexpected job %+v", job)
+		}
+	}
+	if len(s.Annotations) != 2 {
+		t.Fatalf("expected 2 annotations, got %+v", s.Annotations)
+	}
+	for _, ann := range s.Annotations {
+		if ann.Test != "TestInstall" || (ann.CheckRunID != 11 && ann.CheckRunID != 21) {
+			t.Errorf("unexpected annotation %+v", ann)
+		}
+	}
+	if !s.Window.Until.After(since.Add(time.Hour)) {
+		t.Errorf("expected the window to be extended, got %+v", s.Window)
+	}
+
+	// the jobs are persisted, and deliveries received again are left out
+	stored, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range expectedJobs {
+		if !stored.hasJob(id) {
+			t.Errorf("expected job %d to be stored", id)
+		}
+	}
+	requests := server.Requests()
+	replay()
+	if n := len(srv.data().Jobs); n != len(expectedJobs) {
+		t.Errorf("expected %d jobs after the replay, got %d", len(expectedJobs), n)
+	}
+	// only the completed run 101 is fetched again
+	if n := server.Requests() - requests; n != 1 {
+		t.Errorf("expected 1 request to Github during the replay, got %d", n)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	srv := newServer(reportOptions{})
+	srv.update(&snapshot{})
+	hook := newWebhook([]byte(testSecret), testConfig, f, srv)
+	srv.webhook = hook
+	h := srv.handler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.run(ctx)
+
+	payload, err := ioutil.ReadFile("testdata/webhooks/05-workflow_run.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := deliver(h, "workflow_run", payload, "bogus"); code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+	tampered := bytes.Replace(payload, []byte(`"id": 101`), []byte(`"id": 102`), 1)
+	r := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(tampered))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(eventHeader, "workflow_run")
+	r.Header.Set(signature256Header, signature("sha256", payload, testSecret))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a tampered payload, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if n := server.Requests(); n != 0 || len(srv.data().Jobs) != 0 {
+		t.Errorf("expected the deliveries to be rejected, got %d requests to Github and %d jobs", n, len(srv.data().Jobs))
+	}
+
+	// the SHA-1 signature is checked when there's no SHA-256 one
+	r = httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(eventHeader, "workflow_run")
+	r.Header.Set(signatureHeader, signature("sha1", payload, testSecret))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	hook.pending.Wait()
+	if rec.Code != http.StatusAccepted || len(srv.data().Jobs) != 2 {
+		t.Errorf("expected the delivery to be handled, got %d and %d jobs", rec.Code, len(srv.data().Jobs))
+	}
+}
+
+// TestWebhookQueue checks that the deliveries are answered before being
+// handled, and turned down once the queue is full
+func TestWebhookQueue(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	srv := newServer(reportOptions{})
+	srv.update(&snapshot{})
+	hook := newWebhook([]byte(testSecret), testConfig, f, srv)
+	srv.webhook = hook
+	h := srv.handler()
+
+	payload, err := ioutil.ReadFile("testdata/webhooks/05-workflow_run.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < webhookQueueSize; i++ {
+		if code := deliver(h, "workflow_run", payload, testSecret); code != http.StatusAccepted {
+			t.Fatalf("expected status %d, got %d", http.StatusAccepted, code)
+		}
+	}
+	if code := deliver(h, "workflow_run", payload, testSecret); code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d once the queue is full, got %d", http.StatusServiceUnavailable, code)
+	}
+	if n := server.Requests(); n != 0 {
+		t.Errorf("expected no request to Github before the deliveries are handled, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.run(ctx)
+	hook.pending.Wait()
+	if n := len(srv.data().Jobs); n != 2 {
+		t.Errorf("expected 2 jobs once the deliveries are handled, got %d", n)
+	}
+}
+
+// TestWebhookDuringRefresh checks that the jobs delivered while a refresh is
+// in progress are kept when the data it loaded lacks them
+func TestWebhookDuringRefresh(t *testing.T) {
+	server, f := setupFakeGithub(t)
+	addWorkflowRuns(server, 1)
+	srv := newServer(reportOptions{})
+	srv.update(&snapshot{})
+	hook := newWebhook([]byte(testSecret), testConfig, f, srv)
+	srv.webhook = hook
+	h := srv.handler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.run(ctx)
+
+	// the refresh read the runs before the delivery
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		srv.refresh(ctx, func(context.Context) (*snapshot, error) {
+			close(started)
+			<-release
+			return &snapshot{}, nil
+		})
+		close(done)
+	}()
+	<-started
+
+	payload, err := ioutil.ReadFile("testdata/webhooks/05-workflow_run.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := deliver(h, "workflow_run", payload, testSecret); code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, code)
+	}
+	hook.pending.Wait()
+	if n := len(srv.data().Jobs); n != 2 {
+		t.Fatalf("expected 2 jobs once the delivery is handled, got %d", n)
+	}
+
+	close(release)
+	<-done
+	s := srv.data()
+	if len(s.Jobs) != 2 || len(s.Annotations) != 1 {
+		t.Errorf("expected the delivered jobs to be kept after the refresh, got %d jobs and %d annotations", len(s.Jobs), len(s.Annotations))
+	}
+	code, body := get(t, h, "/metrics")
+	if code != http.StatusOK || !strings.Contains(body, `ci_job_runs{repo="linkerd/linkerd2",workflow="CI",job="integration tests"} 1`) {
+		t.Errorf("expected the delivered jobs in the metrics, got %d: %s", code, body)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// dateFormat is the layout of the absolute dates accepted by -since and -until,
+// besides RFC3339
+const dateFormat = "2006-01-02"
+
+var relativeDays = regexp.MustCompile(`^(\d+)([dw])$`)
+
+// window is the period covered by a report. Only the jobs that started within
+// it are taken into account.
+type window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// defaultWindow returns the window covering the month before now
+func defaultWindow(now time.Time) window {
+	return window{Since: now.AddDate(0, -1, 0), Until: now}
+}
+
+// parseWindow builds the window from the values of the -since and -until
+// flags, relative to now. An empty until means now, and an empty since means
+// a month before until.
+func parseWindow(since, until string, now time.Time) (window, error) {
+	w := window{Until: now}
+	var err error
+	if until != "" {
+		if w.Until, err = parseTime(until, now); err != nil {
+			return window{}, fmt.Errorf("invalid -until: %s", err)
+		}
+	}
+	w.Since = w.Until.AddDate(0, -1, 0)
+	if since != "" {
+		if w.Since, err = parseTime(since, now); err != nil {
+			return window{}, fmt.Errorf("invalid -since: %s", err)
+		}
+	}
+	if !w.Since.Before(w.Until) {
+		return window{}, fmt.Errorf("-since (%s) must be before -until (%s)",
+			w.Since.Format(time.RFC822), w.Until.Format(time.RFC822))
+	}
+	return w, nil
+}
+
+// parseTime parses either an absolute date (2006-01-02, in UTC, or RFC3339)
+// or a duration going back from now: a number of days or weeks (7d, 2w) or
+// anything accepted by time.ParseDuration (36h)
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if m := relativeDays.FindStringSubmatch(value); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, err
+		}
+		if m[2] == "w" {
+			n *= 7
+		}
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(dateFormat, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a date (%s or RFC3339) nor a duration (e.g. 7d, 2w, 36h)", value, dateFormat)
+}
+
+// contains tells whether t falls within the window
+func (w window) contains(t time.Time) bool {
+	return !t.Before(w.Since) && !t.After(w.Until)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		since string
+		until string
+		want  window
+		err   string
+	}{
+		{
+			"", "",
+			window{time.Date(2020, 5, 15, 12, 0, 0, 0, time.UTC), now},
+			"",
+		},
+		{
+			"7d", "",
+			window{time.Date(2020, 6, 8, 12, 0, 0, 0, time.UTC), now},
+			"",
+		},
+		{
+			"2w", "36h",
+			window{time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC), time.Date(2020, 6, 14, 0, 0, 0, 0, time.UTC)},
+			"",
+		},
+		{
+			"2020-01-01", "2020-02-01T10:00:00Z",
+			window{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 1, 10, 0, 0, 0, time.UTC)},
+			"",
+		},
+		{
+			"", "2020-03-15",
+			window{time.Date(2020, 2, 15, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)},
+			"",
+		},
+		{"yesterday", "", window{}, "invalid -since"},
+		{"", "7x", window{}, "invalid -until"},
+		{"7d", "90d", window{}, "must be before -until"},
+	}
+
+	for _, tc := range testCases {
+		w, err := parseWindow(tc.since, tc.until, now)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("since %q until %q: expected error containing %q, got %v", tc.since, tc.until, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("since %q until %q: unexpected error: %s", tc.since, tc.until, err)
+			continue
+		}
+		if !w.Since.Equal(tc.want.Since) || !w.Until.Equal(tc.want.Until) {
+			t.Errorf("since %q until %q: expected %+v, got %+v", tc.since, tc.until, tc.want, w)
+		}
+	}
+}